@@ -0,0 +1,49 @@
+package minifed
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// ACMEPathPrefix is where ACME providers serve their ACME resources. The directory is served at
+// ACMEDirectoryPath.
+const (
+	ACMEPathPrefix    = "/acme/"
+	ACMEDirectoryPath = ACMEPathPrefix + "directory"
+)
+
+// acmeDirectory returns the ACME directory (RFC 8555, section 7.1.1) of the ACME provider whose
+// identifier is entityID.
+func acmeDirectory(entityID string) map[string]any {
+	resource := func(name string) string {
+		u, _ := url.JoinPath(entityID, ACMEPathPrefix, name)
+		return u
+	}
+	return map[string]any{
+		"newNonce":   resource("new-nonce"),
+		"newAccount": resource("new-account"),
+		"newOrder":   resource("new-order"),
+		"revokeCert": resource("revoke-cert"),
+		"keyChange":  resource("key-change"),
+		"meta": map[string]any{
+			"website": entityID,
+		},
+	}
+}
+
+// acmeHandler serves the ACME directory of the ACME provider whose identifier is entityID. The
+// other ACME resources aren't implemented yet, and respond with an ACME problem document.
+func acmeHandler(entityID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == ACMEDirectoryPath {
+			writeJSON(w, http.StatusOK, acmeDirectory(entityID))
+			return
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		writeJSON(w, http.StatusNotImplemented, map[string]any{
+			"type":   "urn:ietf:params:acme:error:serverInternal",
+			"detail": "minifed does not implement ACME issuance",
+			"status": http.StatusNotImplemented,
+		})
+	}
+}
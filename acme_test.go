@@ -0,0 +1,70 @@
+package minifed
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestACMEProvider(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    acme_provider: true
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> L
+`)
+	_, claims := entityConfiguration(t, handler, "https://ta.example.com")
+	metadata, _ := claims["metadata"].(map[string]any)
+	advertised, _ := metadata["acme_provider"].(map[string]any)
+	newOrder, _ := advertised["newOrder"].(string)
+	if newOrder != "https://ta.example.com/acme/new-order" {
+		t.Fatalf("acme_provider metadata of TA is %v", metadata["acme_provider"])
+	}
+
+	// The directory served is the one advertised.
+	resp := get(t, handler, "https://ta.example.com"+ACMEDirectoryPath)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("ACME directory: status %d: %s", resp.Code, resp.Body)
+	}
+	var directory map[string]any
+	if err := json.Unmarshal(resp.Body.Bytes(), &directory); err != nil {
+		t.Fatal(err)
+	}
+	if served, want := claimJSON(t, directory), claimJSON(t, advertised); string(served) != string(want) {
+		t.Errorf("ACME directory is %s, but %s is advertised", served, want)
+	}
+
+	// Issuance isn't implemented, so the other resources answer with a problem document.
+	resp = get(t, handler, newOrder)
+	if resp.Code != http.StatusNotImplemented || resp.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("ACME new-order: status %d, content type %q", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	if resp := get(t, handler, "https://l.example.com"+ACMEDirectoryPath); resp.Code != http.StatusNotFound {
+		t.Errorf("ACME directory of L, which isn't an ACME provider: status %d", resp.Code)
+	}
+
+	cfg, err := ParseConfig(writeTestConfig(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    acme_provider: true
+edges:
+  - TA -> L
+`))
+	if err == nil {
+		_, _, err = BuildFederation(cfg)
+	}
+	if err == nil {
+		t.Error("built a federation with a leaf that's an ACME provider")
+	}
+}
@@ -0,0 +1,594 @@
+package minifed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jws"
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/fedentities/storage"
+	"github.com/zachmann/go-oidfed/pkg/unixtime"
+)
+
+// newAdminHandler returns the handler for the admin listener. It serves debugging aids that
+// aren't part of any federation entity, and aren't routed by Host header.
+func newAdminHandler(entities map[string]*Entity) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /verify", handleVerify)
+	mux.HandleFunc("GET /policy-steps", handlePolicySteps)
+	mux.HandleFunc("GET /chain-graph", handleChainGraph)
+	mux.HandleFunc("GET /trust-anchors", trustAnchorsHandler(entities))
+	mux.HandleFunc("GET /trust-anchors/bundle", trustAnchorBundleHandler(entities))
+	mux.HandleFunc("DELETE /resolve-cache", clearResolveCacheHandler(entities))
+	mux.HandleFunc("POST /refresh", refreshHandler(entities))
+	mux.HandleFunc("POST /refresh-statement", refreshStatementHandler(entities))
+	mux.HandleFunc("POST /seed", seedHandler(entities))
+	mux.HandleFunc("GET /storage", storageHandler(entities))
+	mux.HandleFunc("GET /fetch-decoded", decodedFetchHandler(entities))
+	mux.HandleFunc("GET /status-faults", statusFaultsHandler(entities))
+	mux.HandleFunc("POST /status-faults", statusFaultsHandler(entities))
+	return mux
+}
+
+// tlsConfig returns the TLS configuration of the admin listener, or nil if it isn't secured.
+// Client certificates are only verified if given, so that requests without one can be answered
+// with 403 Forbidden by requireClientCert, rather than failing the handshake.
+func (a AdminConfig) tlsConfig() (*tls.Config, error) {
+	if a.ClientCA == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(a.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", a.ClientCA)
+	}
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// requireClientCert refuses requests that didn't present a verified client certificate.
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			writeJSON(w, http.StatusForbidden, errorResponse{"a trusted client certificate is required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the indented JSON response body. The content type defaults to
+// application/json, unless it has already been set.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		slog.Error("failed to write response", "err", err)
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// verifyResponse is the result of resolving and validating the trust chains between a subject
+// and a set of trust anchors.
+type verifyResponse struct {
+	Subject      string   `json:"sub"`
+	TrustAnchors []string `json:"anchor"`
+	Valid        bool     `json:"valid"`
+	// Metadata is the subject's metadata, with the metadata policies of the selected chain
+	// applied.
+	Metadata *oidcfed.Metadata `json:"metadata,omitempty"`
+	// Chains are all the chains with valid signatures, including those whose metadata policies
+	// couldn't be applied. The first chain with valid metadata and the shortest path is selected.
+	Chains []verifiedChain `json:"chains"`
+}
+
+type verifiedChain struct {
+	// Path is the list of entities in the chain, from the subject to the trust anchor.
+	Path        []string          `json:"path"`
+	ExpiresAt   unixtime.Unixtime `json:"exp"`
+	Selected    bool              `json:"selected"`
+	PolicyError string            `json:"policy_error,omitempty"`
+	// ConstraintError is set if the chain violates the constraints of any statement in it.
+	// go-oidfed doesn't check constraints, so they're checked here instead.
+	ConstraintError string `json:"constraint_error,omitempty"`
+	// TimeError is set if any statement in the chain is expired or not yet valid, as the clock
+	// offset of its issuer can make it. go-oidfed doesn't check the times of the statements it
+	// has fetched, so they're checked here instead.
+	TimeError string `json:"time_error,omitempty"`
+}
+
+// checkTimes checks that every statement in chain is valid at the current time, as TimeValid
+// does, but reporting which statement isn't, and why.
+func checkTimes(chain oidcfed.TrustChain) error {
+	for _, statement := range chain {
+		if err := unixtime.VerifyTime(&statement.IssuedAt, &statement.ExpiresAt); err != nil {
+			return fmt.Errorf("statement by %s about %s is %w", statement.Issuer, statement.Subject, err)
+		}
+	}
+	return nil
+}
+
+// checkConstraints checks chain against the constraints in its subordinate statements.
+func checkConstraints(chain oidcfed.TrustChain) error {
+	var entityTypes []string
+	if chain[0].Metadata != nil {
+		entityTypes = chain[0].Metadata.GuessEntityTypes()
+	}
+	for i, statement := range chain[1:] {
+		if statement.Constraints == nil {
+			continue
+		}
+		// The issuer of the statement is i intermediates above the subject of the chain, which are
+		// the subjects of the statements up to and including this one.
+		if maxPathLength := statement.Constraints.MaxPathLength; maxPathLength > 0 && i > maxPathLength {
+			return fmt.Errorf(
+				"%d intermediates below %s exceeds its max_path_length of %d",
+				i, statement.Issuer, maxPathLength,
+			)
+		}
+		if naming := statement.Constraints.NamingConstraints; naming != nil {
+			for _, below := range chain[:i+2] {
+				if err := checkNamingConstraints(naming, below.Subject); err != nil {
+					return fmt.Errorf("%s is not allowed by %s: %w", below.Subject, statement.Issuer, err)
+				}
+			}
+		}
+		for _, entityType := range entityTypes {
+			// The federation_entity type is always allowed.
+			if entityType == "federation_entity" || len(statement.Constraints.AllowedLeafEntityTypes) == 0 {
+				continue
+			}
+			if !slices.Contains(statement.Constraints.AllowedLeafEntityTypes, entityType) {
+				return fmt.Errorf(
+					"entity type %s is not allowed by %s for %s",
+					entityType, statement.Issuer, statement.Subject,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNamingConstraints checks the host of entityID against the permitted and excluded hosts of
+// naming.
+func checkNamingConstraints(naming *oidcfed.NamingConstraints, entityID string) error {
+	u, err := url.Parse(entityID)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	matches := func(constraint string) bool {
+		if strings.HasPrefix(constraint, ".") {
+			return strings.HasSuffix(host, constraint)
+		}
+		return host == constraint
+	}
+	if slices.ContainsFunc(naming.Excluded, matches) {
+		return fmt.Errorf("host %s is excluded", host)
+	}
+	if len(naming.Permitted) > 0 && !slices.ContainsFunc(naming.Permitted, matches) {
+		return fmt.Errorf("host %s is not permitted", host)
+	}
+	return nil
+}
+
+// handleVerify resolves the trust chains between the sub query parameter and each anchor query
+// parameter, and reports whether a valid chain was found along with the result of applying
+// metadata policy to each chain.
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sub := query.Get("sub")
+	anchors := query["anchor"]
+	if sub == "" || len(anchors) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"required parameters 'sub' and 'anchor' not given"})
+		return
+	}
+
+	resolver := oidcfed.TrustResolver{
+		TrustAnchors:   oidcfed.NewTrustAnchorsFromEntityIDs(anchors...),
+		StartingEntity: sub,
+		Types:          query["entity_type"],
+	}
+	chains := resolver.ResolveToValidChainsWithoutVerifyingMetadata()
+
+	response := verifyResponse{Subject: sub, TrustAnchors: anchors, Chains: []verifiedChain{}}
+	selected := -1
+	for i, chain := range chains {
+		verified := verifiedChain{ExpiresAt: chain.ExpiresAt()}
+		for _, statement := range chain {
+			// The chain begins with the subject's entity configuration, followed by the statements
+			// about each entity issued by its superior.
+			verified.Path = append(verified.Path, statement.Issuer)
+		}
+		metadata, err := chain.Metadata()
+		if err != nil {
+			verified.PolicyError = err.Error()
+		}
+		constraintErr := checkConstraints(chain)
+		if constraintErr != nil {
+			verified.ConstraintError = constraintErr.Error()
+		}
+		timeErr := checkTimes(chain)
+		if timeErr != nil {
+			verified.TimeError = timeErr.Error()
+		}
+		valid := err == nil && constraintErr == nil && timeErr == nil
+		if valid && (selected < 0 || len(chain) < len(chains[selected])) {
+			// Select the same chain that the resolve endpoint does, see selectChain.
+			selected = i
+			response.Valid = true
+			response.Metadata = metadata
+		}
+		response.Chains = append(response.Chains, verified)
+	}
+	if selected >= 0 {
+		response.Chains[selected].Selected = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// trustAnchorsHandler lists the trust anchors of the federation with their public keys, in the
+// same shape that go-oidfed uses for configuring trust anchors.
+func trustAnchorsHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		anchors := oidcfed.TrustAnchors{}
+		for _, entity := range entities {
+			// The keys of external trust anchors aren't known to minifed.
+			if entity.Kind != EntityKindTrustAnchor || entity.External {
+				continue
+			}
+			anchors = append(anchors, oidcfed.TrustAnchor{
+				EntityID: entity.Identifier.String(),
+				JWKS:     entity.FedEntity.EntityConfigurationPayload().JWKS,
+			})
+		}
+		slices.SortFunc(anchors, func(a, b oidcfed.TrustAnchor) int {
+			return strings.Compare(a.EntityID, b.EntityID)
+		})
+		writeJSON(w, http.StatusOK, anchors)
+	}
+}
+
+// trustAnchorBundleHandler serves the signed entity configurations of the trust anchors of the
+// federation as a JSON array, ordered by entity id, as they're served at their well-known
+// endpoints. Clients can bootstrap their trust store from it without fetching each of them.
+func trustAnchorBundleHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var anchors []*Entity
+		for _, entity := range entities {
+			if entity.Kind == EntityKindTrustAnchor && !entity.External {
+				anchors = append(anchors, entity)
+			}
+		}
+		slices.SortFunc(anchors, func(a, b *Entity) int {
+			return strings.Compare(a.Identifier.String(), b.Identifier.String())
+		})
+		bundle := []string{}
+		for _, anchor := range anchors {
+			jwt, err := anchor.signedEntityConfiguration()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, errorResponse{fmt.Sprintf("%s: %s", anchor.Name, err)})
+				return
+			}
+			bundle = append(bundle, string(jwt))
+		}
+		writeJSON(w, http.StatusOK, bundle)
+	}
+}
+
+// clearResolveCacheHandler empties the resolve caches of all entities, and reports how many
+// entries each of them held.
+func clearResolveCacheHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cleared := map[string]int{}
+		for _, entity := range entities {
+			if entity.ResolveCache != nil {
+				cleared[entity.Identifier.String()] = entity.ResolveCache.clear()
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"cleared": cleared})
+	}
+}
+
+type refreshedConfiguration struct {
+	Subject   string            `json:"sub"`
+	IssuedAt  unixtime.Unixtime `json:"iat"`
+	ExpiresAt unixtime.Unixtime `json:"exp"`
+}
+
+// refreshHandler signs the entity configuration of the entity given by the sub query parameter
+// anew, or of every entity if it isn't given, and reports the new timestamps.
+func refreshHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub := r.URL.Query().Get("sub")
+		refreshed := []refreshedConfiguration{}
+		for _, name := range slices.Sorted(maps.Keys(entities)) {
+			entity := entities[name]
+			if entity.External || sub != "" && entity.Identifier.String() != sub {
+				continue
+			}
+			payload, err := entity.refreshEntityConfiguration()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, errorResponse{fmt.Sprintf("%s: %s", name, err)})
+				return
+			}
+			refreshed = append(refreshed, refreshedConfiguration{
+				Subject:   payload.Subject,
+				IssuedAt:  payload.IssuedAt,
+				ExpiresAt: payload.ExpiresAt,
+			})
+		}
+		if sub != "" && len(refreshed) == 0 {
+			writeJSON(w, http.StatusNotFound, errorResponse{"no entity has the identifier " + sub})
+			return
+		}
+		writeJSON(w, http.StatusOK, refreshed)
+	}
+}
+
+type refreshedStatement struct {
+	Issuer    string            `json:"iss"`
+	Subject   string            `json:"sub"`
+	IssuedAt  unixtime.Unixtime `json:"iat"`
+	ExpiresAt unixtime.Unixtime `json:"exp"`
+}
+
+// refreshStatementHandler signs the subordinate statement that the entity given by the entity
+// query parameter, by name or identifier, serves about sub anew, from what its storage holds
+// now, and reports the new timestamps. Its fetch endpoint serves the new statement from then on.
+// The version query parameter selects the previous version instead, like that of fetch.
+func refreshStatementHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, sub := r.URL.Query().Get("entity"), r.URL.Query().Get("sub")
+		if name == "" || sub == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"required parameters 'entity' and 'sub' not given"})
+			return
+		}
+		entity := lookupEntity(entities, name)
+		if entity == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{"no entity is named " + name})
+			return
+		}
+		if entity.Storage == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{entity.Name + " has no subordinates"})
+			return
+		}
+		previous, err := statementVersion(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		jwt, err := entity.refreshSubordinateStatement(sub, previous)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		if jwt == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{missingStatement(entity, sub, previous)})
+			return
+		}
+		var refreshed refreshedStatement
+		payload, _ := jwtPayload(jwt)
+		if err := json.Unmarshal(payload, &refreshed); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, refreshed)
+	}
+}
+
+// missingStatement explains why entity has no subordinate statement about sub to serve.
+func missingStatement(entity *Entity, sub string, previous bool) string {
+	if previous {
+		return entity.Name + " has no previous statement about " + sub
+	}
+	return sub + " is not an active subordinate of " + entity.Name
+}
+
+// lookupEntity returns the entity named name, or identified by it, or nil if there is none.
+func lookupEntity(entities map[string]*Entity, name string) *Entity {
+	if entity, ok := entities[name]; ok {
+		return entity
+	}
+	for _, entity := range entities {
+		if entity.Identifier.String() == name {
+			return entity
+		}
+	}
+	return nil
+}
+
+// storedSubordinate is a record of subordinate storage, with its status spelled out.
+type storedSubordinate struct {
+	storage.SubordinateInfo
+	Status string `json:"status"`
+}
+
+type storageDump struct {
+	Entity       string              `json:"entity"`
+	Subordinates []storedSubordinate `json:"subordinates"`
+	// TrustMarkedEntities are the subjects of each trust mark issued by the entity, keyed by trust
+	// mark id and then status.
+	TrustMarkedEntities map[string]map[string][]string `json:"trust_marked_entities"`
+}
+
+// storageHandler dumps the subordinate and trust mark storage of the entity given by the entity
+// query parameter, by name or identifier. Unlike the list endpoint, subordinates of every status
+// are included, with all of the fields they're stored with.
+func storageHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("entity")
+		if name == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"required parameter 'entity' not given"})
+			return
+		}
+		entity := lookupEntity(entities, name)
+		if entity == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{"no entity is named " + name})
+			return
+		}
+
+		dump := storageDump{
+			Entity:              entity.Name,
+			Subordinates:        []storedSubordinate{},
+			TrustMarkedEntities: map[string]map[string][]string{},
+		}
+		if entity.Storage != nil {
+			infos, err := storedSubordinates(entity)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+				return
+			}
+			for _, info := range infos {
+				dump.Subordinates = append(dump.Subordinates, storedSubordinate{info, statusName(info.Status)})
+			}
+		}
+		if entity.TrustMarkStorage != nil {
+			trustDb := entity.TrustMarkStorage.TrustMarkedEntitiesStorage()
+			for _, trustMark := range entity.TrustMarks {
+				statuses := map[string][]string{}
+				for status, list := range map[string]func(string) ([]string, error){
+					"active":  trustDb.Active,
+					"blocked": trustDb.Blocked,
+					"pending": trustDb.Pending,
+				} {
+					ids, err := list(trustMark.ID)
+					if err != nil {
+						writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+						return
+					}
+					for i, id := range ids {
+						// go-oidfed's Badger storage can leave the key prefix of each record on its
+						// entity id.
+						ids[i] = id[strings.LastIndex(id, "|")+1:]
+					}
+					if len(ids) > 0 {
+						slices.Sort(ids)
+						statuses[status] = ids
+					}
+				}
+				dump.TrustMarkedEntities[trustMark.ID] = statuses
+			}
+		}
+		writeJSON(w, http.StatusOK, dump)
+	}
+}
+
+// decodedStatement is a JWT, decoded.
+type decodedStatement struct {
+	Header  jws.Headers     `json:"header"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// decodedFetchHandler serves the subordinate statement that the entity given by the entity query
+// parameter, by name or identifier, serves about sub from its fetch endpoint, with its header and
+// payload decoded. It's signed the same way, but isn't a response of the fetch endpoint, which
+// must be a JWT. The version query parameter selects the previous version, like that of fetch.
+func decodedFetchHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, sub := r.URL.Query().Get("entity"), r.URL.Query().Get("sub")
+		if name == "" || sub == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"required parameters 'entity' and 'sub' not given"})
+			return
+		}
+		entity := lookupEntity(entities, name)
+		if entity == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{"no entity is named " + name})
+			return
+		}
+		if entity.Storage == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{entity.Name + " has no subordinates"})
+			return
+		}
+		previous, err := statementVersion(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		jwt, err := subordinateStatement(entity, sub, previous)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		if jwt == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{missingStatement(entity, sub, previous)})
+			return
+		}
+		message, err := jws.Parse(jwt)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, decodedStatement{
+			Header:  message.Signatures()[0].ProtectedHeaders(),
+			Payload: message.Payload(),
+		})
+	}
+}
+
+// seededEdge is an edge whose subordinate was recorded in the storage of its superior.
+type seededEdge struct {
+	Superior    string `json:"superior"`
+	Subordinate string `json:"subordinate"`
+}
+
+// seedHandler records the subordinate given by the sub query parameter in the storage of the
+// entity given by the entity query parameter, both by name or identifier, as configured by the
+// edge between them. Without sub, every subordinate of the entity is recorded. This establishes
+// trust that wasn't seeded at startup, see Config.SeedTrust.
+func seedHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("entity")
+		if name == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"required parameter 'entity' not given"})
+			return
+		}
+		entity := lookupEntity(entities, name)
+		if entity == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{"no entity is named " + name})
+			return
+		}
+		subordinates := entity.Subordinates
+		if sub := query.Get("sub"); sub != "" {
+			subordinate := lookupEntity(entities, sub)
+			if subordinate == nil || !slices.Contains(entity.Subordinates, subordinate) {
+				writeJSON(w, http.StatusNotFound, errorResponse{sub + " is not a subordinate of " + entity.Name})
+				return
+			}
+			subordinates = []*Entity{subordinate}
+		}
+
+		seeded := []seededEdge{}
+		for _, subordinate := range subordinates {
+			if err := entity.seedSubordinate(subordinate); err != nil {
+				writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+				return
+			}
+			seeded = append(seeded, seededEdge{entity.Identifier.String(), subordinate.Identifier.String()})
+		}
+		writeJSON(w, http.StatusOK, seeded)
+	}
+}
@@ -0,0 +1,288 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+// adminRequest serves a request to the admin API of entities, with the given method and target.
+func adminRequest(t *testing.T, entities map[string]*Entity, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	newAdminHandler(entities).ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+// twoAnchors is a federation of two trust anchors above a leaf, and an external trust anchor.
+const twoAnchors = `
+entities:
+  TB:
+    kind: trust-anchor
+    identifier: https://tb.example.com
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  RTA:
+    kind: trust-anchor
+    identifier: https://real-ta.example.org
+    external: true
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> L
+  - TB -> L
+`
+
+func TestTrustAnchors(t *testing.T) {
+	entities, handler := buildTestFederation(t, twoAnchors)
+	resp := adminRequest(t, entities, http.MethodGet, "/trust-anchors")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("trust anchors: status %d: %s", resp.Code, resp.Body)
+	}
+	var anchors []struct {
+		EntityID string          `json:"entity_id"`
+		JWKS     json.RawMessage `json:"jwks"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &anchors); err != nil {
+		t.Fatal(err)
+	}
+	// External trust anchors are left out, since their keys aren't known.
+	if len(anchors) != 2 || anchors[0].EntityID != "https://ta.example.com" || anchors[1].EntityID != "https://tb.example.com" {
+		t.Fatalf("trust anchors are %s, want TA and TB in order of identifier", resp.Body)
+	}
+	for _, anchor := range anchors {
+		configuration, _ := entityConfiguration(t, handler, anchor.EntityID)
+		if _, err := verifyJWT(configuration, anchor.JWKS); err != nil {
+			t.Errorf("entity configuration of %s doesn't verify with its listed JWKS: %s", anchor.EntityID, err)
+		}
+	}
+
+	// The list configures go-oidfed's trust resolution as it is.
+	var trustAnchors oidcfed.TrustAnchors
+	if err := json.Unmarshal(resp.Body.Bytes(), &trustAnchors); err != nil {
+		t.Fatal(err)
+	}
+	resolver := oidcfed.TrustResolver{TrustAnchors: trustAnchors, StartingEntity: "https://l.example.com"}
+	if chains := resolver.ResolveToValidChains(); len(chains) != 2 {
+		t.Errorf("resolved %d chains with the listed trust anchors, want 2", len(chains))
+	}
+}
+
+func TestRefreshEntityConfiguration(t *testing.T) {
+	entities, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+  R:
+    kind: leaf
+    identifier: https://r.example.com
+    refresh_interval: 1s
+edges:
+  - TA -> L
+  - TA -> R
+`)
+	issuedAt := func(id string) float64 {
+		t.Helper()
+		_, claims := entityConfiguration(t, handler, id)
+		iat, _ := claims["iat"].(float64)
+		return iat
+	}
+	anchor, leaf, refreshed := issuedAt("https://ta.example.com"), issuedAt("https://l.example.com"), issuedAt("https://r.example.com")
+	time.Sleep(10 * time.Millisecond)
+
+	resp := adminRequest(t, entities, http.MethodPost, "/refresh?sub=https://l.example.com")
+	var configurations []refreshedConfiguration
+	if err := json.Unmarshal(resp.Body.Bytes(), &configurations); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d: %s", resp.Code, resp.Body)
+	}
+	if len(configurations) != 1 || configurations[0].Subject != "https://l.example.com" {
+		t.Fatalf("refresh of L refreshed %s", resp.Body)
+	}
+	reported := float64(configurations[0].IssuedAt.UnixMicro()) / 1e6
+	if served := issuedAt("https://l.example.com"); served <= leaf || math.Abs(served-reported) > 0.001 {
+		t.Errorf("entity configuration of L has the iat %f after refreshing, was %f, and reported %f", served, leaf, reported)
+	}
+	if served := issuedAt("https://ta.example.com"); served != anchor {
+		t.Errorf("entity configuration of TA has the iat %v after refreshing L, was %v", served, anchor)
+	}
+	if resp := adminRequest(t, entities, http.MethodPost, "/refresh?sub=https://nope.example.com"); resp.Code != http.StatusNotFound {
+		t.Errorf("refresh of an unknown entity: status %d", resp.Code)
+	}
+
+	// R refreshes its own every second.
+	for deadline := time.Now().Add(3 * time.Second); issuedAt("https://r.example.com") == refreshed; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("entity configuration of R wasn't refreshed by its refresh_interval")
+		}
+	}
+}
+
+// storedRecord is a record of a storage dump, as far as the tests look into it.
+type storedRecord struct {
+	EntityID string `json:"entity_id"`
+	Status   string `json:"status"`
+}
+
+// dumpStorage returns the storage dump of the entity named name.
+func dumpStorage(t *testing.T, entities map[string]*Entity, name string) ([]storedRecord, map[string]map[string][]string) {
+	t.Helper()
+	resp := adminRequest(t, entities, http.MethodGet, "/storage?entity="+name)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("storage of %s: status %d: %s", name, resp.Code, resp.Body)
+	}
+	var dump struct {
+		Subordinates        []storedRecord                 `json:"subordinates"`
+		TrustMarkedEntities map[string]map[string][]string `json:"trust_marked_entities"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	return dump.Subordinates, dump.TrustMarkedEntities
+}
+
+func TestStorage(t *testing.T) {
+	entities, _ := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    trust_marks:
+      - id: https://tm.example.com/certified
+        subjects: [OP]
+  OP:
+    kind: leaf
+    identifier: https://op.example.com
+    openid_provider: true
+  B:
+    kind: leaf
+    identifier: https://b.example.com
+edges:
+  - TA -> OP
+  - edge: TA -> B
+    status: blocked
+`)
+	subordinates, trustMarked := dumpStorage(t, entities, "TA")
+	if len(subordinates) != 2 {
+		t.Fatalf("storage of TA has %d subordinates, want 2: %+v", len(subordinates), subordinates)
+	}
+	if got := subordinates[0]; got.EntityID != "https://b.example.com" || got.Status != "blocked" {
+		t.Errorf("first subordinate of TA is %+v, want B, blocked", got)
+	}
+	if got := subordinates[1]; got.EntityID != "https://op.example.com" || got.Status != "active" {
+		t.Errorf("second subordinate of TA is %+v, want OP, active", got)
+	}
+	if active := trustMarked["https://tm.example.com/certified"]["active"]; !slices.Equal(active, []string{"https://op.example.com"}) {
+		t.Errorf("trust marked entities of TA are %v", trustMarked)
+	}
+
+	// Leaves have no storage to dump.
+	if subordinates, _ := dumpStorage(t, entities, "https://op.example.com"); len(subordinates) != 0 {
+		t.Errorf("storage of OP has the subordinates %+v", subordinates)
+	}
+	if resp := adminRequest(t, entities, http.MethodGet, "/storage?entity=nope"); resp.Code != http.StatusNotFound {
+		t.Errorf("storage of an unknown entity: status %d", resp.Code)
+	}
+}
+
+func TestStorageUnseeded(t *testing.T) {
+	entities, _ := buildTestFederation(t, `
+seed_trust: false
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+  S:
+    kind: leaf
+    identifier: https://s.example.com
+edges:
+  - TA -> L
+  - edge: TA -> S
+    seed: true
+`)
+	subordinates, _ := dumpStorage(t, entities, "TA")
+	if len(subordinates) != 1 || subordinates[0].EntityID != "https://s.example.com" {
+		t.Fatalf("storage of TA has the subordinates %+v, want only S, which is seeded", subordinates)
+	}
+
+	if resp := adminRequest(t, entities, http.MethodPost, "/seed?entity=TA&sub=https://l.example.com"); resp.Code != http.StatusOK {
+		t.Fatalf("seeding L: status %d: %s", resp.Code, resp.Body)
+	}
+	if subordinates, _ := dumpStorage(t, entities, "TA"); len(subordinates) != 2 || subordinates[0].EntityID != "https://l.example.com" {
+		t.Errorf("storage of TA has the subordinates %+v after seeding L, want L and S", subordinates)
+	}
+}
+
+func TestDecodedFetch(t *testing.T) {
+	entities, handler := buildTestFederation(t, testChain)
+	fetched := get(t, handler, "https://im.example.com/fetch?sub=https://l.example.com").Body.Bytes()
+	resp := adminRequest(t, entities, http.MethodGet, "/fetch-decoded?entity=IM&sub=https://l.example.com")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("fetch-decoded: status %d: %s", resp.Code, resp.Body)
+	}
+	var decoded struct {
+		Header  map[string]any  `json:"header"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	// It's the statement the fetch endpoint serves, decoded.
+	payload, _ := jwtPayload(fetched)
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, decoded.Payload); err != nil || compacted.String() != string(payload) {
+		t.Errorf("fetch-decoded has the payload %s, but fetch serves %s", compacted.String(), payload)
+	}
+	if decoded.Header["kid"] != headerKID(t, fetched) || decoded.Header["typ"] != "entity-statement+jwt" {
+		t.Errorf("fetch-decoded has the header %v", decoded.Header)
+	}
+
+	for _, target := range []string{
+		"/fetch-decoded?entity=TA&sub=https://l.example.com",
+		"/fetch-decoded?entity=L&sub=https://l.example.com",
+		"/fetch-decoded?entity=nope&sub=https://l.example.com",
+	} {
+		if resp := adminRequest(t, entities, http.MethodGet, target); resp.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, resp.Code)
+		}
+	}
+}
+
+func TestTrustAnchorBundle(t *testing.T) {
+	entities, handler := buildTestFederation(t, twoAnchors)
+	resp := adminRequest(t, entities, http.MethodGet, "/trust-anchors/bundle")
+	var bundle []string
+	if err := json.Unmarshal(resp.Body.Bytes(), &bundle); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("trust anchor bundle: status %d: %s", resp.Code, resp.Body)
+	}
+	// The bundle holds the entity configurations served by the trust anchors, in order of
+	// identifier, and leaves out the external one.
+	want := []string{"https://ta.example.com", "https://tb.example.com"}
+	if len(bundle) != len(want) {
+		t.Fatalf("trust anchor bundle has %d entity configurations, want %d", len(bundle), len(want))
+	}
+	for i, id := range want {
+		served, claims := entityConfiguration(t, handler, id)
+		if bundle[i] != string(served) {
+			t.Errorf("entity configuration %d of the bundle isn't the one %s serves", i, id)
+		}
+		if _, err := verifyJWT([]byte(bundle[i]), claimJSON(t, claims["jwks"])); err != nil {
+			t.Errorf("entity configuration of %s in the bundle doesn't verify: %s", id, err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package minifed
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Files of an archive written by the export subcommand. The configuration refers to the keys, so
+// that running it reproduces the same federation.
+const (
+	archiveConfig                 = "config.yaml"
+	archiveKeysDir                = "keys"
+	archiveJWKSDir                = "jwks"
+	archiveEntityConfigurationDir = "entity-configurations"
+)
+
+// runExport implements the export subcommand, which builds the federation of a configuration and
+// writes it to a gzipped tarball: the configuration, pinned to the keys that were generated for
+// it, the private keys as PEM files, and the public JWKS and signed entity configuration of each
+// entity. The import subcommand unpacks it again.
+func runExport(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: minifed export config.yaml federation.tar.gz")
+	}
+	filename, output := args[0], args[1]
+	config, err := ParseConfig(filename)
+	if err != nil {
+		return err
+	}
+	document, err := configDocument(filename)
+	if err != nil {
+		return err
+	}
+	entities, _, err := BuildFederation(config)
+	if err != nil {
+		return err
+	}
+	defer closeEntities(entities)
+
+	files := map[string][]byte{}
+	if config.TrustFile != "" {
+		// Copy in the trust file, since it may not be alongside the configuration.
+		trustFile := "trust-file" + strings.ToLower(filepath.Ext(config.TrustFile))
+		if files[trustFile], err = os.ReadFile(config.TrustFile); err != nil {
+			return err
+		}
+		document["trust_file"] = trustFile
+	}
+	// The keys are pinned to their files in the archive, so there's nothing left to cache.
+	delete(document, "key_cache")
+	entityDocuments := configEntityDocuments(document)
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		entity := entities[name]
+		if entity.External {
+			// There's nothing of it to export, beyond its configuration.
+			continue
+		}
+		base := url.PathEscape(name)
+		if err := exportKeys(entity, entityDocuments[name], files); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		jwks, err := json.MarshalIndent(entity.FedEntity.EntityConfigurationPayload().JWKS, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		files[path.Join(archiveJWKSDir, base+".json")] = append(jwks, '\n')
+		jwt, err := entity.signedEntityConfiguration()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		files[path.Join(archiveEntityConfigurationDir, base+".jwt")] = jwt
+	}
+	if files[archiveConfig], err = yaml.Marshal(document); err != nil {
+		return err
+	}
+
+	if err := writeArchive(output, files); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	fmt.Printf("wrote %s, unpack it with: minifed import %s\n", output, output)
+	return nil
+}
+
+// configDocument reads the configuration file filename as a generic document, so that it can be
+// rewritten without losing anything that Config doesn't round-trip as written.
+func configDocument(filename string) (map[string]any, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		if content, err = jsonToYAML(content); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	document := map[string]any{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return document, nil
+}
+
+// configEntityDocuments returns the documents of the entities of a configuration document by
+// name, including those of its federations.
+func configEntityDocuments(document map[string]any) map[string]map[string]any {
+	entities := map[string]map[string]any{}
+	collect := func(group any) {
+		members, _ := group.(map[string]any)
+		for name, entity := range members {
+			if entity, ok := entity.(map[string]any); ok {
+				entities[name] = entity
+			} else {
+				// An entity can't be empty, since it needs a kind, but be lenient.
+				entities[name] = map[string]any{}
+				members[name] = entities[name]
+			}
+		}
+	}
+	collect(document["entities"])
+	federations, _ := document["federations"].(map[string]any)
+	for _, federation := range federations {
+		if federation, ok := federation.(map[string]any); ok {
+			collect(federation["entities"])
+		}
+	}
+	return entities
+}
+
+// exportKeys adds the private keys of entity to files, and points its configuration document at
+// them, pinning their algorithms too.
+func exportKeys(entity *Entity, document map[string]any, files map[string][]byte) error {
+	base := url.PathEscape(entity.Name)
+	if keys, ok := document["keys"].([]any); ok {
+		// The signing key is the one marked so, and the others are published in order.
+		others := slices.Clone(entity.OtherKeys)
+		for i, key := range keys {
+			keyDocument, _ := key.(map[string]any)
+			if keyDocument == nil {
+				keyDocument = map[string]any{}
+				keys[i] = keyDocument
+			}
+			config, signer := entity.Key, entity.SigningPrivateKey
+			if signing, _ := keyDocument["signing"].(bool); !signing {
+				config, signer = others[0].config, others[0].key
+				others = others[1:]
+			}
+			filename := path.Join(archiveKeysDir, fmt.Sprintf("%s-%d.pem", base, i))
+			pem, err := encodeSigningKey(signer)
+			if err != nil {
+				return err
+			}
+			files[filename] = pem
+			keyDocument["file"], keyDocument["alg"] = filename, config.Alg
+		}
+		return nil
+	}
+
+	keyDocument, _ := document["key"].(map[string]any)
+	if keyDocument == nil {
+		keyDocument = map[string]any{}
+		document["key"] = keyDocument
+	}
+	filename := path.Join(archiveKeysDir, base+".pem")
+	pem, err := encodeSigningKey(entity.SigningPrivateKey)
+	if err != nil {
+		return err
+	}
+	files[filename] = pem
+	keyDocument["file"], keyDocument["alg"] = filename, entity.Key.Alg
+	return nil
+}
+
+// writeArchive writes files to a gzipped tarball at filename, in order of name. Keys are only
+// readable by their owner.
+func writeArchive(filename string, files map[string][]byte) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(compressed)
+	now := time.Now()
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		mode := int64(0o644)
+		if strings.HasPrefix(name, archiveKeysDir+"/") {
+			mode = 0o600
+		}
+		header := &tar.Header{
+			Name:    name,
+			Mode:    mode,
+			Size:    int64(len(files[name])),
+			ModTime: now,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// runImport implements the import subcommand, which unpacks an archive written by the export
+// subcommand into a directory, named after the archive by default. Running the configuration in
+// it serves the same federation, with the same keys.
+func runImport(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: minifed import federation.tar.gz [directory]")
+	}
+	filename := args[0]
+	dir := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filename), ".gz"), ".tar")
+	if strings.HasSuffix(filename, ".tgz") {
+		dir = strings.TrimSuffix(filepath.Base(filename), ".tgz")
+	}
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	if err := extractArchive(filename, dir); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	config := filepath.Join(dir, archiveConfig)
+	fmt.Printf("unpacked %s into %s, run it with: minifed %s\n", filename, dir, config)
+	return nil
+}
+
+// extractArchive extracts the regular files of the gzipped tarball filename into dir, refusing to
+// overwrite any file or to write outside of dir.
+func extractArchive(filename, dir string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(compressed)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("%s: refusing to extract to %s", filename, header.Name)
+		}
+		target := filepath.Join(dir, header.Name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, archive); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
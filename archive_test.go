@@ -0,0 +1,103 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	config := writeTestConfig(t, `
+trust_file: trust.csv
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    keys:
+      - alg: ES256
+      - alg: EdDSA
+        signing: true
+edges:
+  - TA -> IM
+`)
+	if err := os.WriteFile(filepath.Join(filepath.Dir(config), "trust.csv"), []byte("superior,subordinate\nIM,L\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	archive, imported := filepath.Join(dir, "federation.tar.gz"), filepath.Join(dir, "imported")
+	var err error
+	captureStdout(t, func() {
+		if err = runExport([]string{config, archive}); err == nil {
+			err = runImport([]string{archive, imported})
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The imported configuration serves the exported federation, with the same keys.
+	content, err := os.ReadFile(filepath.Join(imported, archiveConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig(filepath.Join(imported, archiveConfig))
+	if err != nil {
+		t.Fatalf("%s\n%s", err, content)
+	}
+	entities, handler, err := BuildFederation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeEntities(entities)
+	jwks := map[string]json.RawMessage{}
+	for name, id := range map[string]string{"TA": "https://ta.example.com", "IM": "https://im.example.com", "L": "https://l.example.com"} {
+		exported, err := os.ReadFile(filepath.Join(imported, archiveJWKSDir, name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, claims := entityConfiguration(t, handler, id)
+		var compacted bytes.Buffer
+		_ = json.Compact(&compacted, exported)
+		if served := claimJSON(t, claims["jwks"]); !bytes.Equal(served, compacted.Bytes()) {
+			t.Errorf("%s serves the JWKS %s after importing, but %s was exported", name, served, compacted.Bytes())
+		}
+		jwks[id] = compacted.Bytes()
+
+		exportedConfiguration, err := os.ReadFile(filepath.Join(imported, archiveEntityConfigurationDir, name+".jwt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifyJWT(exportedConfiguration, jwks[id]); err != nil {
+			t.Errorf("exported entity configuration of %s doesn't verify with its JWKS: %s", name, err)
+		}
+	}
+
+	// The subordinate statements, including those of the trust file, carry the same keys, and are
+	// signed with those of their superiors.
+	for _, edge := range [][2]string{{"https://ta.example.com", "https://im.example.com"}, {"https://im.example.com", "https://l.example.com"}} {
+		resp := get(t, handler, edge[0]+"/fetch?sub="+edge[1])
+		if resp.Code != http.StatusOK {
+			t.Fatalf("fetch of %s from %s: status %d: %s", edge[1], edge[0], resp.Code, resp.Body)
+		}
+		if _, err := verifyJWT(resp.Body.Bytes(), jwks[edge[0]]); err != nil {
+			t.Errorf("statement about %s doesn't verify with the exported JWKS of %s: %s", edge[1], edge[0], err)
+		}
+		if statement := claimJSON(t, decodeJWT(t, resp.Body.Bytes())["jwks"]); !bytes.Equal(statement, jwks[edge[1]]) {
+			t.Errorf("statement about %s has the JWKS %s, but %s was exported", edge[1], statement, jwks[edge[1]])
+		}
+	}
+
+	// Importing again refuses to overwrite the files.
+	if err := runImport([]string{archive, imported}); err == nil {
+		t.Error("imported over an existing import")
+	}
+}
@@ -0,0 +1,72 @@
+package minifed
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// printBanner writes a summary of the entities served on addr, with the URL of each of their
+// endpoints and a few curl commands to get started with. In path routing mode, the identifiers
+// of the entities can be requested as they are, rather than through the Host header.
+func printBanner(w io.Writer, entities map[string]*Entity, addr string, pathRouting bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "80"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	base := "http://" + net.JoinHostPort(host, port)
+
+	served := 0
+	for _, entity := range entities {
+		if !entity.External {
+			served++
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "minifed is serving %d entities on %s\n", served, base)
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		entity := entities[name]
+		if entity.External {
+			fmt.Fprintf(tw, "\n%s (%s, external)\t%s\n", name, entity.Kind, entity.Identifier)
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s (%s)\t%s\n", name, entity.Kind, entity.Identifier)
+		paths := entity.endpointPaths()
+		for _, endpoint := range slices.Sorted(maps.Keys(paths)) {
+			fmt.Fprintf(tw, "  %s\t%s\n", endpoint, paths[endpoint])
+		}
+		if disabled := entity.Endpoints.Disabled; len(disabled) > 0 {
+			fmt.Fprintf(tw, "  disabled\t%s\n", strings.Join(slices.Sorted(slices.Values(disabled)), ", "))
+		}
+		curl := func(path string) string {
+			target := base + entity.routePath() + path
+			if pathRouting {
+				target = entity.Identifier.String() + path
+			}
+			// Stop curl from taking the brackets of IPv6 addresses for a glob.
+			flags := ""
+			if strings.ContainsAny(target, "[]") {
+				flags = "-g "
+			}
+			if pathRouting {
+				return fmt.Sprintf("  $ curl %s'%s'\n", flags, target)
+			}
+			return fmt.Sprintf("  $ curl %s'%s' -H \"Host: %s\"\n", flags, target, entity.Identifier.Host)
+		}
+		fmt.Fprint(tw, curl(entity.Endpoints.WellKnown))
+		if entity.serves("list") {
+			fmt.Fprint(tw, curl(entity.Endpoints.List))
+		}
+		if entity.serves("fetch") && len(entity.Subordinates) > 0 {
+			fmt.Fprint(tw, curl(entity.Endpoints.Fetch+"?sub="+entity.Subordinates[0].Identifier.String()))
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,152 @@
+package minifed
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchResult is the outcome of a single request made by runBench.
+type benchResult struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+// runBench implements the bench subcommand, which fires requests at the resolve or fetch endpoint
+// of an entity of a running federation, and reports the throughput and latencies. The entity is
+// reached the same way clients reach it: at -addr, with the Host header and path of its
+// identifier, so both routing modes work.
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: minifed bench -sub URL -anchor URL [flags]\n\n")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "http://localhost:8080", "address the federation is served on")
+	sub := flags.String("sub", "", "entity to resolve, or fetch the subordinate statement of")
+	anchor := flags.String("anchor", "", "trust anchor to resolve against, which is also the entity requested; for fetch, the superior of sub")
+	endpoint := flags.String("endpoint", "resolve", "endpoint to request: resolve or fetch")
+	path := flags.String("path", "", "path of the endpoint (default: the default path of -endpoint)")
+	requests := flags.Int("n", 1000, "number of requests to make")
+	concurrency := flags.Int("c", 10, "number of requests to make at once")
+	_ = flags.Parse(args)
+
+	if *sub == "" || *anchor == "" {
+		flags.Usage()
+		return errors.New("bench: -sub and -anchor are required")
+	}
+	if *requests < 1 || *concurrency < 1 {
+		return errors.New("bench: -n and -c must be positive")
+	}
+	query := url.Values{"sub": {*sub}}
+	switch *endpoint {
+	case "resolve":
+		query.Set("trust_anchor", *anchor)
+		if *path == "" {
+			*path = DefaultResolvePath
+		}
+	case "fetch":
+		if *path == "" {
+			*path = DefaultFetchPath
+		}
+	default:
+		return fmt.Errorf("bench: unknown endpoint %q, must be resolve or fetch", *endpoint)
+	}
+	target, err := url.Parse(*anchor)
+	if err != nil {
+		return fmt.Errorf("bench: -anchor: %w", err)
+	}
+	requestURL := strings.TrimSuffix(*addr, "/") + strings.TrimSuffix(target.EscapedPath(), "/") + *path + "?" + query.Encode()
+
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+	do := func() benchResult {
+		request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		if err != nil {
+			return benchResult{err: err}
+		}
+		request.Host = target.Host
+		start := time.Now()
+		response, err := client.Do(request)
+		if err != nil {
+			return benchResult{latency: time.Since(start), err: err}
+		}
+		_, err = io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+		return benchResult{latency: time.Since(start), status: response.StatusCode, err: err}
+	}
+
+	fmt.Printf("benchmarking %s (Host: %s) with %d requests, %d at once\n", requestURL, target.Host, *requests, *concurrency)
+	results := make([]benchResult, *requests)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = do()
+			}
+		}()
+	}
+	for i := range results {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	printBenchReport(os.Stdout, results, time.Since(start))
+	return nil
+}
+
+// printBenchReport writes the throughput, latency percentiles, and error counts of results, which
+// took elapsed to collect.
+func printBenchReport(w io.Writer, results []benchResult, elapsed time.Duration) {
+	latencies := make([]time.Duration, 0, len(results))
+	statuses := map[int]int{}
+	errs := map[string]int{}
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			errs[result.err.Error()]++
+			failed++
+			continue
+		}
+		statuses[result.status]++
+		if result.status != http.StatusOK {
+			failed++
+		}
+		latencies = append(latencies, result.latency)
+	}
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+
+	fmt.Fprintf(w, "\n%d requests in %s, %.1f requests/s\n", len(results), elapsed.Round(time.Millisecond),
+		float64(len(results))/elapsed.Seconds())
+	fmt.Fprintf(w, "latency: p50 %s, p90 %s, p99 %s, max %s\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(1))
+	fmt.Fprintf(w, "failed: %d\n", failed)
+	for _, status := range slices.Sorted(maps.Keys(statuses)) {
+		fmt.Fprintf(w, "status %d: %d\n", status, statuses[status])
+	}
+	for _, err := range slices.Sorted(maps.Keys(errs)) {
+		fmt.Fprintf(w, "error %q: %d\n", err, errs[err])
+	}
+}
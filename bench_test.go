@@ -0,0 +1,55 @@
+package minifed
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	_, handler := buildTestFederation(t, testChain)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, test := range []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "resolve",
+			args: []string{"-sub", "https://l.example.com", "-anchor", "https://ta.example.com"},
+			want: []string{"/resolve?", "(Host: ta.example.com)", "20 requests in", "failed: 0\n", "status 200: 20\n"},
+		},
+		{
+			name: "fetch",
+			args: []string{"-endpoint", "fetch", "-sub", "https://l.example.com", "-anchor", "https://im.example.com"},
+			want: []string{"/fetch?sub=", "(Host: im.example.com)", "failed: 0\n", "status 200: 20\n"},
+		},
+		{
+			// L isn't a subordinate of TA, so every request fails.
+			name: "failing",
+			args: []string{"-endpoint", "fetch", "-sub", "https://l.example.com", "-anchor", "https://ta.example.com"},
+			want: []string{"failed: 20\n", "status 404: 20\n"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			output := captureStdout(t, func() {
+				err = runBench(append([]string{"-addr", server.URL, "-n", "20", "-c", "4"}, test.args...))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("output doesn't contain %q:\n%s", want, output)
+				}
+			}
+		})
+	}
+
+	if err := runBench([]string{"-addr", server.URL, "-sub", "https://l.example.com", "-anchor", "https://ta.example.com", "-endpoint", "list"}); err == nil {
+		t.Error("benchmarked an unknown endpoint")
+	}
+}
@@ -0,0 +1,86 @@
+package minifed
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+// handleChainGraph resolves the trust chains between the sub query parameter and each anchor
+// query parameter, like handleVerify, and renders them as a Graphviz DOT digraph. Each entity on
+// a chain is a node, and each subordinate statement an edge from its issuer to its subject,
+// labeled with what the statement carries besides keys. The chain that the resolve endpoint would
+// use is drawn in bold, and invalid chains are dashed, so that it shows why one path through the
+// federation was chosen over another.
+func handleChainGraph(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sub := query.Get("sub")
+	anchors := query["anchor"]
+	if sub == "" || len(anchors) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"required parameters 'sub' and 'anchor' not given"})
+		return
+	}
+
+	resolver := oidcfed.TrustResolver{
+		TrustAnchors:   oidcfed.NewTrustAnchorsFromEntityIDs(anchors...),
+		StartingEntity: sub,
+		Types:          query["entity_type"],
+	}
+	chains := resolver.ResolveToValidChainsWithoutVerifyingMetadata()
+	selected := selectChain(chains)
+
+	var dot strings.Builder
+	fmt.Fprintf(&dot, "digraph %s {\n", strconv.Quote("trust chains of "+sub))
+	dot.WriteString("  node [shape=box];\n")
+	fmt.Fprintf(&dot, "  %s [style=filled, fillcolor=lightgrey];\n", strconv.Quote(sub))
+	for _, anchor := range anchors {
+		fmt.Fprintf(&dot, "  %s [shape=doubleoctagon];\n", strconv.Quote(anchor))
+	}
+	if len(chains) == 0 {
+		dot.WriteString("  label=\"no trust chain found\";\n")
+	}
+	for i, chain := range chains {
+		attributes := []string{fmt.Sprintf("tooltip=\"chain %d\"", i)}
+		switch {
+		case i == selected:
+			attributes = append(attributes, "penwidth=3", "color=blue")
+		case chainError(chain) != nil:
+			attributes = append(attributes, "style=dashed", "color=red")
+		default:
+			attributes = append(attributes, "color=grey")
+		}
+		// The chain begins with the subject's entity configuration, followed by the subordinate
+		// statements about each entity issued by its superior.
+		for _, statement := range chain[1:] {
+			edge := attributes
+			if label := statementLabel(statement); label != "" {
+				edge = append(slices.Clone(attributes), "label="+strconv.Quote(label))
+			}
+			fmt.Fprintf(&dot, "  %s -> %s [%s];\n",
+				strconv.Quote(statement.Issuer), strconv.Quote(statement.Subject), strings.Join(edge, ", "))
+		}
+	}
+	dot.WriteString("}\n")
+
+	w.Header().Set("Content-Type", "text/vnd.graphviz")
+	_, _ = w.Write([]byte(dot.String()))
+}
+
+// statementLabel names the parts of a subordinate statement that bear on the chain it's in.
+func statementLabel(statement *oidcfed.EntityStatement) string {
+	var parts []string
+	if statement.MetadataPolicy != nil {
+		parts = append(parts, "metadata_policy")
+	}
+	if len(statement.MetadataPolicyCrit) > 0 {
+		parts = append(parts, "metadata_policy_crit")
+	}
+	if statement.Constraints != nil {
+		parts = append(parts, "constraints")
+	}
+	return strings.Join(parts, ", ")
+}
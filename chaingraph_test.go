@@ -0,0 +1,74 @@
+package minifed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestChainGraph(t *testing.T) {
+	// L has a direct chain to TA, and a longer one through IM, whose naming constraints leave L out.
+	buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  TX:
+    kind: trust-anchor
+    identifier: https://tx.example.com
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> L
+  - edge: TA -> IM
+    naming_constraints:
+      permitted: [im.example.com]
+  - edge: IM -> L
+    metadata_policy:
+      federation_entity:
+        organization_name: {value: Example}
+`)
+	graph := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handleChainGraph(recorder, httptest.NewRequest(http.MethodGet, "/chain-graph?"+query, nil))
+		return recorder
+	}
+	resp := graph("sub=https://l.example.com&anchor=https://ta.example.com")
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "text/vnd.graphviz" {
+		t.Fatalf("chain-graph: status %d, content type %q: %s", resp.Code, resp.Header().Get("Content-Type"), resp.Body)
+	}
+	// The chains are numbered in the order go-oidfed resolves them in, which isn't stable.
+	dot := regexp.MustCompile(`tooltip="chain \d+", `).ReplaceAllString(resp.Body.String(), "")
+	for _, want := range []string{
+		`digraph "trust chains of https://l.example.com" {` + "\n",
+		`  "https://l.example.com" [style=filled, fillcolor=lightgrey];` + "\n",
+		`  "https://ta.example.com" [shape=doubleoctagon];` + "\n",
+		// The direct chain is the one resolve would use.
+		`  "https://ta.example.com" -> "https://l.example.com" [penwidth=3, color=blue];` + "\n",
+		// The chain through IM is invalid, and labeled with the policy of IM.
+		`  "https://im.example.com" -> "https://l.example.com" [style=dashed, color=red, label="metadata_policy"];` + "\n",
+		`  "https://ta.example.com" -> "https://im.example.com" [style=dashed, color=red, label="constraints"];` + "\n",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("chain graph doesn't have %q:\n%s", want, resp.Body)
+		}
+	}
+	if strings.Contains(dot, "no trust chain found") {
+		t.Errorf("chain graph says no trust chain was found:\n%s", resp.Body)
+	}
+
+	// Without a chain to the anchor, the graph only has the nodes that were asked about.
+	resp = graph("sub=https://l.example.com&anchor=https://tx.example.com")
+	if want := `label="no trust chain found"`; resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), want) || strings.Contains(resp.Body.String(), " -> ") {
+		t.Errorf("chain-graph to TX: status %d, want a graph with no edges labeled %s:\n%s", resp.Code, want, resp.Body)
+	}
+	if resp := graph("sub=https://l.example.com"); resp.Code != http.StatusBadRequest {
+		t.Errorf("chain-graph without an anchor: status %d, want 400", resp.Code)
+	}
+}
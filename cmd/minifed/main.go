@@ -0,0 +1,75 @@
+// Command minifed sets up web servers for hosting various OIDF entities.
+//
+// It supports configuration of federations with arbitrary layouts. See minifed.Config for the
+// configuration file layout. The configuration can also be written as JSON, with the same keys, in
+// a file with a .json extension.
+//
+// Run `go run ./cmd/minifed init` to write a starter config.yaml for a small federation to build
+// on, and then run it with `go run ./cmd/minifed config.yaml`. Pass -otlp-endpoint (or set
+// OTEL_EXPORTER_OTLP_ENDPOINT) to export traces of the requests served, including the hops made
+// while resolving trust chains.
+//
+// Once the web servers are running, manipulate the Host header to talk to them, e.g.
+// `curl http://localhost:8080/fetch?sub=https://im.example.com -H "Host: ta.example.com"`. An
+// entity whose identifier has a path, e.g. https://example.com/ta, is served under that path, and
+// entities can share a host that way. Where that isn't possible, pass -path-routing to serve each
+// entity under a path prefix instead, e.g.
+// `curl http://localhost:8080/entities/ta/fetch?sub=http://localhost:8080/entities/im`.
+//
+// An admin API is served separately, on localhost:8081 by default (see -admin-addr). It has
+// debugging aids such as `/verify?sub=https://l.example.com&anchor=https://ta.example.com`, which
+// resolves and validates the trust chains from sub to anchor and reports the result as JSON, and
+// `/trust-anchors`, which lists the trust anchors and their keys for seeding a client's trust
+// store, or `/trust-anchors/bundle` for their signed entity configurations instead.
+// `DELETE /resolve-cache` empties the caches of the resolve endpoints, if resolve_cache_ttl is set,
+// `POST /refresh?sub=...` signs an entity configuration anew with fresh timestamps, and
+// `POST /refresh-statement?entity=ta&sub=...` the subordinate statement that ta serves about sub,
+// `POST /seed?entity=ta&sub=im` establishes trust along an edge left unseeded by seed_trust, and
+// `/storage?entity=ta` dumps everything an entity has in storage, whatever its status, and
+// `/fetch-decoded?entity=ta&sub=...` shows the statement that the fetch endpoint would serve about
+// sub, decoded. It and /refresh-statement take version=previous, like the fetch endpoint, for the
+// previous version of the statement instead, see minifed.Edge.Previous. `/status-faults` lists the
+// status faults of the entities' endpoints, and
+// `POST /status-faults?entity=ta&endpoint=fetch&status=503&fraction=0.5` sets one, or turns it on
+// and off with enabled=true or false, see minifed.EntityConfig.StatusFaults. `/policy-steps`, with
+// the same parameters as /verify, shows the metadata of sub after the metadata policy of each hop
+// is applied, from the trust anchor down, and `/chain-graph` renders the chains as a Graphviz
+// digraph, the one that resolve uses in bold. Pass -debug to log the metadata that the resolve
+// endpoints respond with, too. The admin API can be restricted to holders of trusted client
+// certificates, see minifed.AdminConfig.
+//
+// Send the process SIGHUP to reload the federation from the config file, e.g. after adding an
+// entity or an edge, or pass -watch to reload it whenever the file changes. Everything about the
+// entities and edges is reloaded, with freshly generated keys unless they're read from files or the
+// key_cache, while the listeners keep their addresses, timeouts, and TLS configuration. If the new
+// configuration can't be built, the one already running keeps being served.
+//
+// Every listener applies the read, write, and idle timeouts under the server key of the config,
+// which default to 10s, 30s, and 2m respectively, and its limits on the size of requests, see
+// minifed.ServerConfig.
+//
+// Run `go run ./cmd/minifed bench -sub https://l.example.com -anchor https://ta.example.com`
+// against a running federation to measure how fast its resolve endpoint is, or its fetch endpoint
+// with -endpoint fetch. Run `go run ./cmd/minifed diff old.yaml new.yaml` to see which entities and
+// edges a change to a configuration adds, removes, or changes, before applying it. Like diff(1), it
+// exits with status 1 if there are any differences.
+//
+// Run `go run ./cmd/minifed export config.yaml federation.tar.gz` to bundle a federation up for
+// someone else: the configuration, the keys generated for it, and the public JWKS and signed entity
+// configuration of each entity. `go run ./cmd/minifed import federation.tar.gz` unpacks it into a
+// directory whose config.yaml serves the same federation with the same keys, see
+// minifed.KeyConfig.File. `go run ./cmd/minifed golden config.yaml testdata` writes the JWKS and
+// decoded entity configuration of each entity, serialized the same way every run and without
+// timestamps or signatures, for committing as golden files. Pin the keys with key_cache or key
+// files for the output to stay the same.
+//
+// To drive a federation from Go code instead, e.g. from the tests of a client, import
+// github.com/inahga/minifed and build it with minifed.New, which serves it without binding any
+// ports if need be.
+package main
+
+import "github.com/inahga/minifed"
+
+func main() {
+	minifed.Main()
+}
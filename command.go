@@ -0,0 +1,122 @@
+package minifed
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// Main runs the minifed command with the arguments of the process, see cmd/minifed. It only
+// returns once a subcommand is done, and exits the process if it fails or once serving stops.
+func Main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			if err := runBench(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "init":
+			if err := runInit(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "import":
+			if err := runImport(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "golden":
+			if err := runGolden(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "diff":
+			changed, err := runDiff(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			if changed {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	adminAddr := flag.String("admin-addr", "localhost:8081", "address to serve the admin API on, or empty to disable it")
+	pathRouting := flag.String("path-routing", "", "serve all entities under path prefixes of this base URL, e.g. http://localhost:8080, rather than routing by Host header (overrides path_routing in the config)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector to export traces to, e.g. localhost:4318 (default: tracing disabled, unless OTEL_EXPORTER_OTLP_ENDPOINT is set)")
+	watchConfig := flag.Bool("watch", false, "reload the federation whenever the config file (or its trust file) changes, as on SIGHUP")
+	debug := flag.Bool("debug", false, "log at debug level, e.g. the metadata in the responses of resolve endpoints")
+	flag.Parse()
+
+	if *debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	if err := setupTracing(context.Background(), *otlpEndpoint); err != nil {
+		log.Fatalf("failed to set up tracing: %s", err)
+	}
+
+	federation := &reloader{filename: flag.Arg(0), pathRouting: *pathRouting}
+	config, err := federation.parse()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tlsConfig *tls.Config
+	if *adminAddr != "" {
+		if tlsConfig, err = config.Admin.tlsConfig(); err != nil {
+			log.Fatalf("admin: %s", err)
+		}
+		federation.newAdminHandler = func(entities map[string]*Entity) http.Handler {
+			adminHandler := newAdminHandler(entities)
+			if tlsConfig != nil {
+				adminHandler = requireClientCert(adminHandler)
+			}
+			return adminHandler
+		}
+	}
+	if err := federation.load(config); err != nil {
+		log.Fatal(err)
+	}
+
+	// TODO: TLS with certs issued from self-signed root certificate. Also means we'd need to deal
+	// with SNI for making requests.
+	listeners := []*listener{{name: "federation", server: config.Server.newServer(":8080", &federation.federation)}}
+
+	if *adminAddr != "" {
+		adminServer := config.Server.newServer(*adminAddr, &federation.admin)
+		adminServer.TLSConfig = tlsConfig
+		admin := &listener{name: "admin API", server: adminServer}
+		if tlsConfig != nil {
+			// Client certificates are required, see requireClientCert.
+			admin.certFile, admin.keyFile = config.Admin.Cert, config.Admin.Key
+		}
+		listeners = append(listeners, admin)
+	}
+
+	if err := listenAll(listeners); err != nil {
+		log.Fatal(err)
+	}
+	printBanner(os.Stdout, federation.entities, listeners[0].server.Addr, config.PathRouting != "")
+
+	reloads := make(chan string)
+	go federation.reloadOn(reloads)
+	go notifyHangup(reloads)
+	if *watchConfig {
+		watched := []string{federation.filename}
+		if config.TrustFile != "" {
+			watched = append(watched, config.TrustFile)
+		}
+		go watch(watched, watchInterval, reloads)
+	}
+	log.Fatal(serveAll(listeners))
+}
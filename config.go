@@ -0,0 +1,955 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/fedentities/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// EntityKind is the type of the entity. It doesn't necessarily map 1:1 to OIDF Entities, but
+// instead different kind of entites that can exist in a minifed federation.
+type EntityKind string
+
+const (
+	// EntityKindLeaf is a OIDF leaf that does nothing.
+	EntityKindLeaf         EntityKind = "leaf"
+	EntityKindTrustAnchor  EntityKind = "trust-anchor"
+	EntityKindIntermediate EntityKind = "intermediate"
+	// Being an ACME provider is a capability of a trust anchor or intermediate, rather than a kind
+	// of its own. See EntityConfig.ACMEProvider.
+)
+
+// Default paths for the endpoints served by each entity.
+const (
+	DefaultWellKnownPath = "/.well-known/openid-federation"
+	DefaultFetchPath     = "/fetch"
+	DefaultListPath      = "/list"
+	DefaultResolvePath   = "/resolve"
+
+	DefaultTrustMarkPath       = "/trustmark"
+	DefaultTrustMarkStatusPath = "/trustmark/status"
+	DefaultTrustMarkListPath   = "/trustmark/list"
+
+	DefaultJWKSPath       = "/jwks.json"
+	DefaultSignedJWKSPath = "/jwks.jwt"
+)
+
+type Config struct {
+	Entities map[string]EntityConfig
+	Edges    []Edge
+	// Federations group entities and edges into independent federations, keyed by name. Entity
+	// names are shared by all of them, and the top-level entities and edges. Each federation is
+	// validated to have a single trust anchor at its root, and edges may only relate entities of
+	// the same federation, unless they're marked cross_federation.
+	Federations map[string]FederationConfig
+	Server      ServerConfig
+	Admin       AdminConfig
+	// Unreachable is what to do about subordinates that aren't served at the host of their
+	// identifier, e.g. because another entity already claims it: "error" (the default) fails
+	// startup, and "warn" only logs them.
+	Unreachable string
+	// ResolveCacheTTL is how long the responses of the resolve endpoints are cached for, written
+	// as a duration such as "30s". Responses are never cached past their expiry. Unset disables
+	// the cache.
+	ResolveCacheTTL time.Duration `yaml:"resolve_cache_ttl"`
+	// PathRouting serves all entities on the single host of this base URL, e.g.
+	// http://localhost:8080, each under a path prefix derived from its name. The identifiers of
+	// the entities are derived the same way, in place of the configured ones. This is for
+	// setups that can't route by Host header.
+	PathRouting string `yaml:"path_routing"`
+	// RequireHTTPS rejects identifiers with the http scheme, which are allowed by default since
+	// minifed only serves plain HTTP.
+	RequireHTTPS bool `yaml:"require_https"`
+	// TrustFile lists more edges, for federations too big to write out by hand. It's a CSV file
+	// with a header row, or a YAML or JSON list of edges, see readTrustFile. A relative path is
+	// relative to the configuration file.
+	TrustFile string `yaml:"trust_file"`
+	// DefaultKey configures the signing keys of entities, where they don't configure their own.
+	DefaultKey DefaultKeyConfig `yaml:"default_key"`
+	// KeyCache is a directory to cache generated keys in, so that later runs reuse them rather
+	// than generating them anew, for as long as the key config of each stays the same. Keys that
+	// are read from a file aren't cached. A relative path is relative to the configuration file.
+	KeyCache string `yaml:"key_cache"`
+	// Storage tunes the databases entities keep their records in.
+	Storage StorageConfig
+	// SeedTrust records a subordinate for each edge in the storage of its superior at startup,
+	// which is the default. Set it to false to start with empty storage, and seed trust with the
+	// admin API instead, or only for the edges that set seed.
+	SeedTrust *bool `yaml:"seed_trust"`
+	// Retry configures retrying the requests made to entities that minifed doesn't serve.
+	Retry RetryConfig
+}
+
+// FederationConfig is a group of entities and edges that make up one federation.
+type FederationConfig struct {
+	Entities map[string]EntityConfig
+	Edges    []Edge
+}
+
+// Default timeouts for every listener. The write timeout leaves room for resolving long trust
+// chains, since each hop is fetched while the response is being written.
+const (
+	DefaultReadTimeout  = 10 * time.Second
+	DefaultWriteTimeout = 30 * time.Second
+	DefaultIdleTimeout  = 2 * time.Minute
+)
+
+// Default limits on the size of the requests to every listener. Requests to federation entities
+// are small, but the limits are generous, since they're only there to stop abuse.
+const (
+	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+	DefaultMaxBodyBytes   = 1 << 20
+)
+
+// ServerConfig are the timeouts applied to every listener, written as durations such as "5s".
+// Unset timeouts take on their default value, and a negative timeout disables it. The limits on
+// the size of requests are the same.
+type ServerConfig struct {
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// MaxHeaderBytes limits the size of the request line and headers of each request, give or
+	// take the few kilobytes that net/http allows beyond it. Larger ones are refused with 431
+	// Request Header Fields Too Large. It can't be disabled.
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+	// MaxBodyBytes limits the size of the body of each request. Larger ones are refused with 413
+	// Content Too Large before they reach any entity.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+}
+
+func (s *ServerConfig) setDefaults() {
+	if s.ReadTimeout == 0 {
+		s.ReadTimeout = DefaultReadTimeout
+	}
+	if s.WriteTimeout == 0 {
+		s.WriteTimeout = DefaultWriteTimeout
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = DefaultIdleTimeout
+	}
+	if s.MaxHeaderBytes == 0 {
+		s.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if s.MaxBodyBytes == 0 {
+		s.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+}
+
+func (s ServerConfig) validate() error {
+	if s.MaxHeaderBytes < 0 {
+		return errors.New("server: max_header_bytes must be positive")
+	}
+	return nil
+}
+
+// AdminConfig secures the admin listener. When ClientCA is set, the admin API is served over TLS
+// with Cert and Key, and only to clients presenting a certificate issued by one of the CAs in
+// ClientCA. Other requests are refused with 403 Forbidden.
+type AdminConfig struct {
+	// ClientCA is a PEM file of the CA certificates that client certificates are verified
+	// against.
+	ClientCA string `yaml:"client_ca"`
+	// Cert and Key are PEM files of the admin listener's own certificate and private key.
+	Cert string
+	Key  string
+}
+
+// newServer creates a server for handler on addr with the configured timeouts and limits.
+func (s ServerConfig) newServer(addr string, handler http.Handler) *http.Server {
+	s.setDefaults()
+	return &http.Server{
+		Addr:           addr,
+		Handler:        limitBody(handler, s.MaxBodyBytes),
+		ReadTimeout:    s.ReadTimeout,
+		WriteTimeout:   s.WriteTimeout,
+		IdleTimeout:    s.IdleTimeout,
+		MaxHeaderBytes: s.MaxHeaderBytes,
+	}
+}
+
+// Edge is a trust relationship, written as "superior -> subordinate". A chain of relationships can
+// be written as "a -> b -> c", which is shorthand for the edges "a -> b" and "b -> c".
+//
+// An edge is either written as a plain string, or as a mapping with the string under the edge
+// key alongside options for the relationship. The options apply to every relationship in a chain.
+type Edge struct {
+	Edge string
+	// AllowedEntityTypes constrains the entity types of the leaves below the subordinate. It is
+	// included in the constraints of the subordinate statement.
+	AllowedEntityTypes []string `yaml:"allowed_entity_types"`
+	// MaxPathLength constrains the number of intermediates between the superior and the leaves
+	// below it. go-oidfed omits a max_path_length of zero from statements, so it must be
+	// positive.
+	MaxPathLength int `yaml:"max_path_length"`
+	// NamingConstraints constrain the hosts of the identifiers of the entities below the
+	// subordinate, as in the naming_constraints claim.
+	NamingConstraints *NamingConstraints `yaml:"naming_constraints"`
+	// CrossFederation allows the edge to relate entities of different federations.
+	CrossFederation bool `yaml:"cross_federation"`
+	// Status is the status the subordinate is recorded with by the superior: active (the
+	// default), blocked, pending, or inactive. Only active subordinates are listed, and have
+	// statements issued about them.
+	Status string
+	// Seed overrides seed_trust for this edge, so that it's seeded at startup or not regardless.
+	Seed *bool
+	// MetadataPolicy is the metadata_policy of the subordinate statement, by entity type, then
+	// metadata parameter, then policy operator, e.g.
+	//
+	//	metadata_policy:
+	//	  openid_relying_party:
+	//	    grant_types: {subset_of: [authorization_code]}
+	MetadataPolicy map[string]map[string]map[string]any `yaml:"metadata_policy"`
+	// MetadataPolicyCrit are the policy operators of MetadataPolicy that clients must understand
+	// to use the statement, for its metadata_policy_crit claim. Each must be used in the policy.
+	MetadataPolicyCrit []string `yaml:"metadata_policy_crit"`
+	// Previous is a prior version of the subordinate statement, which the fetch endpoint of the
+	// superior serves when asked for version=previous, whatever the status of the subordinate.
+	// This is for checking how clients handle a statement that has been replaced. It's written
+	// with the options of an edge that go into the statement, e.g.
+	//
+	//	previous:
+	//	  max_path_length: 2
+	//
+	// and carries the same keys of the subordinate as the current statement.
+	Previous *Edge
+	// PreviousAge is how long before now the previous statement was issued, written as a duration
+	// such as "24h". Its iat and exp are backdated by as much.
+	PreviousAge time.Duration `yaml:"previous_age"`
+
+	// federation is the name of the federation the edge was declared in, if any.
+	federation string
+}
+
+// NamingConstraints are hosts whose entities are permitted, or excluded. A host beginning with
+// a dot covers all of its subdomains instead.
+type NamingConstraints struct {
+	Permitted []string
+	Excluded  []string
+}
+
+func (e *Edge) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&e.Edge)
+	}
+	type edge Edge
+	return node.Decode((*edge)(e))
+}
+
+// seeded reports whether the edge is seeded at startup, which is up to seedTrust unless the edge
+// says otherwise.
+func (e Edge) seeded(seedTrust bool) bool {
+	if e.Seed != nil {
+		return *e.Seed
+	}
+	return seedTrust
+}
+
+// constraints returns the constraints for the subordinate statement issued across the edge, or nil
+// if there are none.
+func (e Edge) constraints() *oidcfed.ConstraintSpecification {
+	if len(e.AllowedEntityTypes) == 0 && e.MaxPathLength == 0 && e.NamingConstraints == nil {
+		return nil
+	}
+	constraints := &oidcfed.ConstraintSpecification{
+		AllowedLeafEntityTypes: e.AllowedEntityTypes,
+		MaxPathLength:          e.MaxPathLength,
+	}
+	if e.NamingConstraints != nil {
+		constraints.NamingConstraints = &oidcfed.NamingConstraints{
+			Permitted: e.NamingConstraints.Permitted,
+			Excluded:  e.NamingConstraints.Excluded,
+		}
+	}
+	return constraints
+}
+
+// metadataPolicy returns the metadata policy and the critical policy operators for the
+// subordinate statement issued across the edge, or nil if there are none.
+func (e Edge) metadataPolicy() (*oidcfed.MetadataPolicies, []oidcfed.PolicyOperatorName, error) {
+	if len(e.MetadataPolicy) == 0 {
+		if len(e.MetadataPolicyCrit) > 0 {
+			return nil, nil, errors.New("metadata_policy_crit must be given with a metadata_policy")
+		}
+		return nil, nil, nil
+	}
+	encoded, err := json.Marshal(e.MetadataPolicy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metadata_policy is not valid JSON: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var policies oidcfed.MetadataPolicies
+	if err := decoder.Decode(&policies); err != nil {
+		return nil, nil, fmt.Errorf("metadata_policy: %w", err)
+	}
+
+	used := map[oidcfed.PolicyOperatorName]bool{}
+	for _, entityType := range slices.Sorted(maps.Keys(e.MetadataPolicy)) {
+		var policy oidcfed.MetadataPolicy
+		// Decoded again on its own, since MetadataPolicies doesn't index its fields by type.
+		encoded, _ := json.Marshal(e.MetadataPolicy[entityType])
+		if err := json.Unmarshal(encoded, &policy); err != nil {
+			return nil, nil, fmt.Errorf("metadata_policy %s: %w", entityType, err)
+		}
+		if err := policy.Verify(entityType); err != nil {
+			return nil, nil, fmt.Errorf("metadata_policy: %w", err)
+		}
+		for _, entry := range policy {
+			for operator := range entry {
+				used[operator] = true
+			}
+		}
+	}
+	var crit []oidcfed.PolicyOperatorName
+	for _, operator := range e.MetadataPolicyCrit {
+		if !used[oidcfed.PolicyOperatorName(operator)] {
+			return nil, nil, fmt.Errorf("metadata_policy_crit: operator %s is not used in metadata_policy", operator)
+		}
+		crit = append(crit, oidcfed.PolicyOperatorName(operator))
+	}
+	return &policies, crit, nil
+}
+
+// status returns the storage status of the subordinate across the edge.
+func (e Edge) status() (storage.Status, error) {
+	switch e.Status {
+	case "", "active":
+		return storage.StatusActive, nil
+	case "blocked":
+		return storage.StatusBlocked, nil
+	case "pending":
+		return storage.StatusPending, nil
+	case "inactive":
+		return storage.StatusInactive, nil
+	default:
+		return 0, fmt.Errorf("status must be active, blocked, pending, or inactive, not %q", e.Status)
+	}
+}
+
+// previous checks the previous version of the subordinate statement issued across the edge, and
+// returns it, or nil if there's none.
+func (e Edge) previous() (*Edge, error) {
+	if e.PreviousAge < 0 {
+		return nil, errors.New("previous_age must be positive")
+	}
+	if e.Previous == nil {
+		if e.PreviousAge != 0 {
+			return nil, errors.New("previous_age must be given with previous")
+		}
+		return nil, nil
+	}
+	previous := *e.Previous
+	if previous.Edge != "" || previous.CrossFederation || previous.Status != "" || previous.Seed != nil ||
+		previous.Previous != nil || previous.PreviousAge != 0 {
+		return nil, errors.New("previous can only set the options of an edge that go into its statement")
+	}
+	if previous.MaxPathLength < 0 {
+		return nil, errors.New("previous: max_path_length must be positive")
+	}
+	if _, _, err := previous.metadataPolicy(); err != nil {
+		return nil, fmt.Errorf("previous: %w", err)
+	}
+	return &previous, nil
+}
+
+// parseEdge splits an edge into the names of the entities it relates, from the superior to the
+// subordinate.
+func parseEdge(edge string) ([]string, error) {
+	parts := strings.Split(edge, "->")
+	if len(parts) < 2 {
+		return nil, errors.New("edge must be of the form \"superior -> subordinate\"")
+	}
+	names := make([]string, 0, len(parts))
+	for i, part := range parts {
+		name := strings.TrimSpace(part)
+		if name == "" {
+			return nil, fmt.Errorf("missing entity name at position %d", i)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// checkEdgeKinds checks that an entity of kind superior can have one of kind subordinate below
+// it. Leaves can't be superiors, and trust anchors can't be subordinates, unless the edge crosses
+// federations, where one federation's trust anchor can be subordinate to another's.
+func checkEdgeKinds(superior, subordinate EntityKind, crossFederation bool) error {
+	if superior == EntityKindLeaf {
+		return fmt.Errorf("a %s can't be a superior", superior)
+	}
+	if subordinate == EntityKindTrustAnchor && !crossFederation {
+		return fmt.Errorf("a %s can't be a subordinate, unless the edge is cross_federation", subordinate)
+	}
+	return nil
+}
+
+type EntityConfig struct {
+	Kind       EntityKind
+	Identifier string
+	// External marks an entity that minifed doesn't serve, such as a real trust anchor above a
+	// local intermediate. It can only be a superior, and stands for its identifier in the
+	// authority hints of its subordinates, so it takes nothing but a kind and an identifier.
+	// Statements about its subordinates have to be issued by whoever does serve it.
+	External   bool
+	Endpoints  Endpoints
+	TrustMarks []TrustMarkConfig `yaml:"trust_marks"`
+	// OpenIDProvider makes a leaf advertise openid_provider metadata, with the entity identifier as
+	// its issuer.
+	OpenIDProvider bool `yaml:"openid_provider"`
+	// WebFinger makes an OpenID provider answer WebFinger queries for its issuer, for use with
+	// OpenID Connect discovery.
+	WebFinger bool
+	// ACMEProvider makes a trust anchor or intermediate serve an ACME directory, and advertise it
+	// as acme_provider metadata. It's only a stub, nothing can be issued yet.
+	ACMEProvider bool `yaml:"acme_provider"`
+	// JWKSURI and SignedJWKSURI make an entity serve its public keys as a JWK set, or as a signed
+	// JWK set respectively, and reference them as jwks_uri or signed_jwks_uri in its metadata,
+	// rather than leaving clients to the keys inline in the entity configuration.
+	JWKSURI       bool `yaml:"jwks_uri"`
+	SignedJWKSURI bool `yaml:"signed_jwks_uri"`
+	// ContentTypes override the Content-Type of the responses of the entity's endpoints, keyed by
+	// endpoint name as in the endpoints block, or e.g. webfinger and acme. An empty content type
+	// omits the header. This is for checking that clients reject responses of the wrong type.
+	ContentTypes map[string]string `yaml:"content_types"`
+	// AuthorityHints are the names of superiors of the entity to list first in its authority
+	// hints, in this order. The rest follow in order of identifier.
+	AuthorityHints []string `yaml:"authority_hints"`
+	// StatusFaults fail requests to the entity's endpoints with an error status, keyed by endpoint
+	// name like content_types. They can be changed while served with the admin API.
+	StatusFaults map[string]StatusFault `yaml:"status_faults"`
+	// FederationEntity is included in the federation_entity metadata, alongside the endpoints.
+	FederationEntity FederationEntityConfig `yaml:"federation_entity"`
+	// Metadata is merged into the metadata claim of the entity configuration as it is, keyed by
+	// entity type. Each entity type must be a JSON object, whose parameters are added to the ones
+	// minifed sets for the entity type, if any, overriding them. This is for extension or draft
+	// metadata that minifed doesn't know about.
+	Metadata map[string]map[string]any
+	// Claims are added to the entity configuration as they are, overriding the claims of the same
+	// name that minifed sets.
+	Claims map[string]any
+	// Crit lists the claims of the entity configuration that must be understood, as its crit
+	// claim. Each of them must be present in the entity configuration, e.g. through Claims.
+	Crit []string
+	// TrustMarkIssuers is the trust_mark_issuers claim of the entity configuration, listing the
+	// identifiers of the entities trusted to issue each trust mark, keyed by trust mark id.
+	TrustMarkIssuers map[string][]string `yaml:"trust_mark_issuers"`
+	// TrustMarkTypes are the ids of the trust marks recognized by a trust anchor, published as
+	// the trust_mark_types claim of its entity configuration. Entities below the trust anchor may
+	// only issue trust marks of these types. Unset recognizes any trust mark.
+	TrustMarkTypes []string `yaml:"trust_mark_types"`
+	// RefreshInterval is how often the entity configuration is signed anew with fresh timestamps,
+	// written as a duration such as "1m". Unset means it's only signed anew on request through
+	// the admin API.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Key configures the signing key of the entity.
+	Key KeyConfig
+	// Keys configure several keys for the entity, in place of Key. All of them are published in
+	// its JWKS, and the one marked signing signs everything.
+	Keys []KeyConfig
+	// ClockOffset shifts the iat, exp, and nbf of everything the entity signs, written as a
+	// duration such as "+30s" or "-2m", as if its clock were off by that much. This is for
+	// reproducing clock skew between entities.
+	ClockOffset time.Duration `yaml:"clock_offset"`
+
+	// federation is the name of the federation the entity was declared in, if any.
+	federation string
+}
+
+// KeyConfig configures a key of an entity.
+type KeyConfig struct {
+	// KID replaces the key id of the signing key, which is its JWK thumbprint by default. It's
+	// used in the JWKS of the entity, and in the headers of the JWTs it signs.
+	KID string `yaml:"kid"`
+	// HeaderKID is the key id put in the headers of the JWTs the entity signs, if it should differ
+	// from the one in its JWKS. This is for checking that clients reject JWTs signed with a key
+	// they can't find.
+	HeaderKID string `yaml:"header_kid"`
+	// Alg is the JWS algorithm the entity signs with, which determines the type of its key, see
+	// keyAlgorithms. It defaults to the alg of default_key, or else ES512.
+	Alg string
+	// Signing marks the one key of keys that the entity signs with. Only it can have a
+	// header_kid.
+	Signing bool
+	// FaultAlg is a testing-only fault: the entity configuration is signed with this algorithm,
+	// by a throwaway key of its type, rather than by the key in the JWKS, whose alg stays as it
+	// is. The header keeps the key id of the signing key, so clients ought to reject the entity
+	// configuration. Only the signing key can have a fault_alg, and it must differ from alg.
+	FaultAlg string `yaml:"fault_alg"`
+	// File is a PEM file of the PKCS #8 private key to use, rather than generating one, so that
+	// the entity keeps its key across runs. The key must be of the type alg calls for. A relative
+	// path is relative to the configuration file.
+	File string
+}
+
+// DefaultKeyConfig configures the signing keys of the entities that don't configure their own.
+type DefaultKeyConfig struct {
+	Alg string
+}
+
+// entityKeys returns the keys of entity, the signing key first, with their algorithms filled in.
+func (c *Config) entityKeys(entity EntityConfig) []KeyConfig {
+	keys := []KeyConfig{entity.Key}
+	if len(entity.Keys) > 0 {
+		keys = nil
+		for _, key := range entity.Keys {
+			if key.Signing {
+				keys = append([]KeyConfig{key}, keys...)
+			} else {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for i := range keys {
+		keys[i].Alg = c.keyAlg(keys[i])
+	}
+	return keys
+}
+
+// keyAlg returns the algorithm of key.
+func (c *Config) keyAlg(key KeyConfig) string {
+	if key.Alg != "" {
+		return key.Alg
+	}
+	if c.DefaultKey.Alg != "" {
+		return c.DefaultKey.Alg
+	}
+	return DefaultKeyAlg
+}
+
+// FederationEntityConfig are the informational parameters of the federation_entity metadata of an
+// entity, for e.g. a client to display. They're named after the metadata parameters.
+type FederationEntityConfig struct {
+	OrganizationName string `yaml:"organization_name"`
+	Contacts         []string
+	LogoURI          string `yaml:"logo_uri"`
+	HomepageURI      string `yaml:"homepage_uri"`
+}
+
+// TrustMarkConfig is a trust mark issued by an entity. The issuer serves the trust mark endpoints,
+// and each subject lists the trust mark in its entity configuration.
+type TrustMarkConfig struct {
+	ID string
+	// Lifetime of issued trust marks, in seconds. Zero means the trust marks don't expire.
+	Lifetime float64
+	// Subjects are the names of the entities that hold the trust mark.
+	Subjects []string
+	// Owner is the name of the entity that owns the trust mark, if it isn't the issuer. The owner
+	// delegates issuance to the issuer, and the trust anchors above the issuer list the owner under
+	// trust_mark_owners.
+	Owner string
+	// DelegationLifetime of the delegation from the owner, in seconds. Zero means the delegation
+	// doesn't expire.
+	DelegationLifetime float64 `yaml:"delegation_lifetime"`
+}
+
+// Endpoints are the paths at which an entity serves its endpoints. Unset paths take on their
+// default value. The fetch, list, and resolve endpoints are only served by trust anchors and
+// intermediates, the trust mark endpoints only by trust mark issuers, and the JWKS endpoints only
+// by entities that have them enabled.
+type Endpoints struct {
+	// Disabled are the names of endpoints the entity would otherwise serve, but doesn't, e.g.
+	// fetch to check how clients cope with an intermediate that can't be fetched from. Disabled
+	// endpoints aren't advertised in the metadata, and answer 404 Not Found.
+	Disabled []string
+
+	WellKnown       string `yaml:"well_known"`
+	Fetch           string
+	List            string
+	Resolve         string
+	TrustMark       string `yaml:"trust_mark"`
+	TrustMarkStatus string `yaml:"trust_mark_status"`
+	TrustMarkList   string `yaml:"trust_mark_list"`
+	JWKS            string
+	SignedJWKS      string `yaml:"signed_jwks"`
+}
+
+func (e *Endpoints) setDefaults() {
+	if e.WellKnown == "" {
+		e.WellKnown = DefaultWellKnownPath
+	}
+	if e.Fetch == "" {
+		e.Fetch = DefaultFetchPath
+	}
+	if e.List == "" {
+		e.List = DefaultListPath
+	}
+	if e.Resolve == "" {
+		e.Resolve = DefaultResolvePath
+	}
+	if e.TrustMark == "" {
+		e.TrustMark = DefaultTrustMarkPath
+	}
+	if e.TrustMarkStatus == "" {
+		e.TrustMarkStatus = DefaultTrustMarkStatusPath
+	}
+	if e.TrustMarkList == "" {
+		e.TrustMarkList = DefaultTrustMarkListPath
+	}
+	if e.JWKS == "" {
+		e.JWKS = DefaultJWKSPath
+	}
+	if e.SignedJWKS == "" {
+		e.SignedJWKS = DefaultSignedJWKSPath
+	}
+}
+
+// validateEndpointPaths checks that the endpoints of an entity, keyed by name, are valid and
+// don't collide.
+func validateEndpointPaths(paths map[string]string) error {
+	seen := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(paths)) {
+		path := paths[name]
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("%s endpoint path %q must begin with /", name, path)
+		}
+		if other, ok := seen[path]; ok {
+			return fmt.Errorf("%s and %s endpoints share the path %q", name, other, path)
+		}
+		seen[path] = name
+	}
+	return nil
+}
+
+// ParseConfig reads the configuration file at filename. It's validated when the federation is
+// built. Files with a .json extension are read as JSON, with the same keys as the YAML layout, and
+// anything else as YAML. Keys that aren't settings are rejected, reported with their path.
+func ParseConfig(filename string) (Config, error) {
+	var config Config
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	isJSON := strings.EqualFold(filepath.Ext(filename), ".json")
+	if isJSON {
+		if content, err = jsonToYAML(content); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	// The lines of JSON are lost in converting it, so only the paths of unknown keys are reported.
+	if err := decodeStrict(content, &config, !isJSON); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if config.TrustFile != "" && !filepath.IsAbs(config.TrustFile) {
+		config.TrustFile = filepath.Join(filepath.Dir(filename), config.TrustFile)
+	}
+	if config.KeyCache != "" && !filepath.IsAbs(config.KeyCache) {
+		config.KeyCache = filepath.Join(filepath.Dir(filename), config.KeyCache)
+	}
+	resolveKeyFiles(config.Entities, filepath.Dir(filename))
+	for _, federation := range config.Federations {
+		resolveKeyFiles(federation.Entities, filepath.Dir(filename))
+	}
+	return config, nil
+}
+
+// resolveKeyFiles makes the relative key files of entities relative to dir.
+func resolveKeyFiles(entities map[string]EntityConfig, dir string) {
+	resolve := func(key *KeyConfig) {
+		if key.File != "" && !filepath.IsAbs(key.File) {
+			key.File = filepath.Join(dir, key.File)
+		}
+	}
+	for name, entity := range entities {
+		resolve(&entity.Key)
+		entity.Keys = slices.Clone(entity.Keys)
+		for i := range entity.Keys {
+			resolve(&entity.Keys[i])
+		}
+		entities[name] = entity
+	}
+}
+
+// jsonToYAML converts a JSON document to YAML, so that it's decoded through the yaml tags and
+// unmarshalers of Config like any other configuration.
+func jsonToYAML(content []byte) ([]byte, error) {
+	var document any
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(document)
+}
+
+// validate checks the entities of the configuration, and fills in the defaults of their
+// endpoints. The entities and edges of each federation are merged into the top-level ones. Edges
+// are only checked here insofar as federations are concerned, the rest is checked when the
+// federation is built.
+//
+// A Config is passed around by value, but its maps and slices are shared with the caller, so
+// validate works on copies of the ones it changes, and the caller's Config can be validated again,
+// such as when building it twice or rolling back a reload.
+func (c *Config) validate() error {
+	c.Entities = maps.Clone(c.Entities)
+	c.Edges = slices.Clone(c.Edges)
+	c.Federations = maps.Clone(c.Federations)
+	if c.Admin.ClientCA != "" && (c.Admin.Cert == "" || c.Admin.Key == "") {
+		return errors.New("admin: cert and key must be present with client_ca")
+	}
+	switch c.Unreachable {
+	case "", "error", "warn":
+	default:
+		return fmt.Errorf("unreachable must be error or warn, not %q", c.Unreachable)
+	}
+	if err := c.Storage.validate(); err != nil {
+		return err
+	}
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.validate(); err != nil {
+		return err
+	}
+	if c.DefaultKey.Alg != "" {
+		if err := checkKeyAlg(c.DefaultKey.Alg); err != nil {
+			return fmt.Errorf("default_key: %w", err)
+		}
+	}
+	if err := c.mergeFederations(); err != nil {
+		return err
+	}
+	if err := c.mergeTrustFile(); err != nil {
+		return err
+	}
+	// Entities are checked in order of name, so that the first error is the same on every run.
+	for _, key := range slices.Sorted(maps.Keys(c.Entities)) {
+		entity := c.Entities[key]
+		if err := c.validateEntity(key, &entity); err != nil {
+			return withFederation(entity.federation, err)
+		}
+		c.Entities[key] = entity
+	}
+	return c.validateFederations()
+}
+
+func (c *Config) validateEntity(key string, entity *EntityConfig) error {
+	if entity.Kind == "" {
+		return fmt.Errorf("%s: kind must be present", key)
+	}
+	if entity.External {
+		// The identifier of an external entity can't be derived in path routing mode.
+		if entity.Identifier == "" {
+			return fmt.Errorf("%s: identifier must be present", key)
+		}
+		bare := EntityConfig{Kind: entity.Kind, Identifier: entity.Identifier, External: true, federation: entity.federation}
+		if !reflect.DeepEqual(*entity, bare) {
+			return fmt.Errorf("%s: external entities take nothing but a kind and an identifier", key)
+		}
+		return nil
+	}
+	if entity.Identifier == "" && c.PathRouting == "" {
+		return fmt.Errorf("%s: identifier must be present", key)
+	}
+	entity.Endpoints.setDefaults()
+	if entity.OpenIDProvider && entity.Kind != EntityKindLeaf {
+		return fmt.Errorf("%s: only leaves can be OpenID providers", key)
+	}
+	if entity.WebFinger && !entity.OpenIDProvider {
+		return fmt.Errorf("%s: webfinger requires openid_provider", key)
+	}
+	if entity.ACMEProvider && entity.Kind != EntityKindTrustAnchor && entity.Kind != EntityKindIntermediate {
+		return fmt.Errorf("%s: only trust anchors and intermediates can be ACME providers", key)
+	}
+	if len(entity.TrustMarkTypes) > 0 && entity.Kind != EntityKindTrustAnchor {
+		return fmt.Errorf("%s: only trust anchors can declare trust mark types", key)
+	}
+	for _, trustMark := range entity.TrustMarks {
+		if trustMark.ID == "" {
+			return fmt.Errorf("%s: trust mark id must be present", key)
+		}
+		for _, subject := range trustMark.Subjects {
+			if _, ok := c.Entities[subject]; !ok {
+				return fmt.Errorf("%s: undefined reference to node %s in trust mark %s", key, subject, trustMark.ID)
+			}
+			if c.Entities[subject].External {
+				return fmt.Errorf("%s: trust mark %s can't be issued to external entity %s", key, trustMark.ID, subject)
+			}
+		}
+		if trustMark.Owner != "" {
+			if _, ok := c.Entities[trustMark.Owner]; !ok {
+				return fmt.Errorf("%s: undefined reference to node %s in trust mark %s", key, trustMark.Owner, trustMark.ID)
+			}
+			if c.Entities[trustMark.Owner].External {
+				return fmt.Errorf("%s: trust mark %s can't be owned by external entity %s", key, trustMark.ID, trustMark.Owner)
+			}
+			if trustMark.Owner == key {
+				return fmt.Errorf("%s: trust mark %s can't be delegated to its owner", key, trustMark.ID)
+			}
+		}
+	}
+	if err := validateKeys(entity); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	for _, endpoint := range slices.Sorted(maps.Keys(entity.StatusFaults)) {
+		if err := entity.StatusFaults[endpoint].validate(); err != nil {
+			return fmt.Errorf("%s: status fault of %s: %w", key, endpoint, err)
+		}
+	}
+	for _, entityType := range slices.Sorted(maps.Keys(entity.Metadata)) {
+		metadata := entity.Metadata[entityType]
+		if metadata == nil {
+			return fmt.Errorf("%s: metadata %s must be an object", key, entityType)
+		}
+		if _, err := json.Marshal(metadata); err != nil {
+			return fmt.Errorf("%s: metadata %s is not valid JSON: %w", key, entityType, err)
+		}
+	}
+	return nil
+}
+
+// validateKeys checks that entity configures either key or keys, and that exactly one of keys is
+// the signing key.
+func validateKeys(entity *EntityConfig) error {
+	if entity.Key.Alg != "" {
+		if err := checkKeyAlg(entity.Key.Alg); err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+	}
+	if entity.Key.FaultAlg != "" {
+		if err := checkKeyAlg(entity.Key.FaultAlg); err != nil {
+			return fmt.Errorf("key: fault_alg: %w", err)
+		}
+	}
+	if len(entity.Keys) == 0 {
+		return nil
+	}
+	if entity.Key != (KeyConfig{}) {
+		return errors.New("key and keys can't both be present")
+	}
+	signing := 0
+	kids := map[string]bool{}
+	for i, key := range entity.Keys {
+		if key.Alg != "" {
+			if err := checkKeyAlg(key.Alg); err != nil {
+				return fmt.Errorf("keys %d: %w", i, err)
+			}
+		}
+		if key.FaultAlg != "" {
+			if err := checkKeyAlg(key.FaultAlg); err != nil {
+				return fmt.Errorf("keys %d: fault_alg: %w", i, err)
+			}
+		}
+		if key.Signing {
+			signing++
+		} else if key.HeaderKID != "" {
+			return fmt.Errorf("keys %d: only the signing key can have a header_kid", i)
+		} else if key.FaultAlg != "" {
+			return fmt.Errorf("keys %d: only the signing key can have a fault_alg", i)
+		}
+		if key.KID != "" {
+			if kids[key.KID] {
+				return fmt.Errorf("keys %d: kid %s is used by more than one key", i, key.KID)
+			}
+			kids[key.KID] = true
+		}
+	}
+	if signing != 1 {
+		return fmt.Errorf("exactly one of keys must be signing, found %d", signing)
+	}
+	return nil
+}
+
+// mergeFederations moves the entities and edges of each federation into the top-level ones,
+// recording which federation they came from.
+func (c *Config) mergeFederations() error {
+	if len(c.Federations) > 0 && c.Entities == nil {
+		c.Entities = map[string]EntityConfig{}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Federations)) {
+		federation := c.Federations[name]
+		for _, key := range slices.Sorted(maps.Keys(federation.Entities)) {
+			entity := federation.Entities[key]
+			if _, ok := c.Entities[key]; ok {
+				return fmt.Errorf("federation %s: %s: entity is defined more than once", name, key)
+			}
+			entity.federation = name
+			c.Entities[key] = entity
+		}
+		for _, edge := range federation.Edges {
+			edge.federation = name
+			c.Edges = append(c.Edges, edge)
+		}
+	}
+	c.Federations = nil
+	return nil
+}
+
+// validateFederations checks that edges don't cross federations unless allowed, and that each
+// federation has a single root, which is a trust anchor.
+func (c *Config) validateFederations() error {
+	members := map[string][]string{}
+	for key, entity := range c.Entities {
+		if entity.federation != "" {
+			members[entity.federation] = append(members[entity.federation], key)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	hasSuperior := map[string]bool{}
+	for _, edge := range c.Edges {
+		names, err := parseEdge(edge.Edge)
+		if err != nil {
+			return withFederation(edge.federation, fmt.Errorf("invalid edge %q: %w", edge.Edge, err))
+		}
+		for _, name := range names {
+			if _, ok := c.Entities[name]; !ok {
+				return withFederation(edge.federation, fmt.Errorf("undefined reference to node %s in edge %q", name, edge.Edge))
+			}
+		}
+
+		home := edge.federation
+		if home == "" {
+			home = c.Entities[names[0]].federation
+		}
+		for i, name := range names {
+			federation := c.Entities[name].federation
+			if federation != home && !edge.CrossFederation {
+				return withFederation(edge.federation, fmt.Errorf(
+					"edge %q relates %s outside of federation %q, set cross_federation to allow it",
+					edge.Edge, name, home,
+				))
+			}
+			if i > 0 && federation == c.Entities[names[i-1]].federation {
+				hasSuperior[name] = true
+			}
+		}
+	}
+
+	for _, federation := range slices.Sorted(maps.Keys(members)) {
+		var roots []string
+		for _, key := range members[federation] {
+			if !hasSuperior[key] {
+				roots = append(roots, key)
+			}
+		}
+		slices.Sort(roots)
+		if len(roots) != 1 {
+			return fmt.Errorf("federation %s: must have exactly one root, found %d %v", federation, len(roots), roots)
+		}
+		if kind := c.Entities[roots[0]].Kind; kind != EntityKindTrustAnchor {
+			return fmt.Errorf("federation %s: root %s must be a trust anchor, not %s", federation, roots[0], kind)
+		}
+	}
+	return nil
+}
+
+// withFederation attributes err to the named federation, if any.
+func withFederation(federation string, err error) error {
+	if federation == "" {
+		return err
+	}
+	return fmt.Errorf("federation %s: %w", federation, err)
+}
@@ -0,0 +1,113 @@
+package minifed
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateErrorIsStable(t *testing.T) {
+	for _, test := range []struct {
+		name, config, want string
+	}{
+		{
+			// Each entity is invalid in its own way, so the error names whichever is checked first.
+			name: "entities",
+			config: `
+entities:
+  B:
+    kind: leaf
+  A:
+    kind: leaf
+    identifier: https://a.example.com
+    webfinger: true
+  C:
+    identifier: https://c.example.com
+`,
+			want: "A: webfinger requires openid_provider",
+		},
+		{
+			name: "metadata",
+			config: `
+entities:
+  E:
+    kind: leaf
+    identifier: https://e.example.com
+    metadata:
+      openid_relying_party: null
+      federation_entity: null
+`,
+			want: "E: metadata federation_entity must be an object",
+		},
+		{
+			name: "status faults",
+			config: `
+entities:
+  D:
+    kind: leaf
+    identifier: https://d.example.com
+    status_faults:
+      well_known: {status: 200}
+      fetch: {status: 200}
+`,
+			want: "D: status fault of fetch: status must be between 400 and 599, not 200",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filename := writeTestConfig(t, test.config)
+			for range 20 {
+				cfg, err := ParseConfig(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, _, err = BuildFederation(cfg); err == nil || err.Error() != test.want {
+					t.Fatalf("building the federation: %v, want %s", err, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildConfigTwice(t *testing.T) {
+	cfg, err := ParseConfig(writeTestConfig(t, `
+trust_file: trust.yaml
+entities:
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - edge: TA -> L
+    cross_federation: true
+federations:
+  one:
+    entities:
+      TA:
+        kind: trust-anchor
+        identifier: https://ta.example.com
+      IM:
+        kind: intermediate
+        identifier: https://im.example.com
+    edges:
+      - TA -> IM
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(cfg.TrustFile), "trust.yaml"), []byte("- edge: IM -> L\n  cross_federation: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		entities, handler, err := BuildFederation(cfg)
+		if err != nil {
+			t.Fatalf("building the federation, time %d: %s", i+1, err)
+		}
+		_, claims := entityConfiguration(t, handler, "https://l.example.com")
+		closeEntities(entities)
+		if hints := string(claimJSON(t, claims["authority_hints"])); hints != `["https://im.example.com","https://ta.example.com"]` {
+			t.Errorf("authority_hints of L are %s, time %d", hints, i+1)
+		}
+	}
+	if len(cfg.Entities) != 1 || len(cfg.Edges) != 1 || len(cfg.Federations) != 1 || cfg.TrustFile == "" {
+		t.Errorf("building the federation changed its config: %+v", cfg)
+	}
+}
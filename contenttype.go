@@ -0,0 +1,68 @@
+package minifed
+
+import (
+	"net/http"
+	"strings"
+)
+
+// contentTypeOverrides returns the configured content types of e, keyed by endpoint path.
+func (e *Entity) contentTypeOverrides() map[string]string {
+	paths := e.endpointPaths()
+	overrides := map[string]string{}
+	for endpoint, contentType := range e.ContentTypes {
+		overrides[paths[endpoint]] = contentType
+	}
+	return overrides
+}
+
+// contentTypeHandler overrides the Content-Type of the responses of next, for requests to the
+// paths in overrides. A path that ends in a slash covers every path below it. An empty content
+// type omits the header.
+func contentTypeHandler(next http.Handler, overrides map[string]string) http.Handler {
+	if len(overrides) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType, ok := overrides[r.URL.Path]
+		if !ok {
+			for path, override := range overrides {
+				if strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path) {
+					contentType, ok = override, true
+					break
+				}
+			}
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&contentTypeWriter{ResponseWriter: w, contentType: contentType}, r)
+	})
+}
+
+// contentTypeWriter replaces the Content-Type header just before the response is written.
+type contentTypeWriter struct {
+	http.ResponseWriter
+	contentType string
+	wroteHeader bool
+}
+
+func (c *contentTypeWriter) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if c.contentType == "" {
+			// A nil value keeps net/http from sniffing a content type of its own.
+			c.Header()["Content-Type"] = nil
+		} else {
+			c.Header().Set("Content-Type", c.contentType)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *contentTypeWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,56 @@
+package minifed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestContentTypeOverrides(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    content_types:
+      well_known: application/json
+      fetch: ""
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> L
+`)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	for _, test := range []struct {
+		target string
+		want   []string
+	}{
+		{target: "https://ta.example.com/.well-known/openid-federation", want: []string{"application/json"}},
+		// An empty content type omits the header, rather than letting net/http sniff one.
+		{target: "https://ta.example.com/fetch?sub=https://l.example.com", want: nil},
+		// The other endpoints, and other entities, keep their content types.
+		{target: "https://ta.example.com/list", want: []string{"application/json"}},
+		{target: "https://l.example.com/.well-known/openid-federation", want: []string{"application/entity-statement+jwt"}},
+	} {
+		// Served for real, since net/http sniffs a content type where a recorder doesn't.
+		target, _ := url.Parse(test.target)
+		req, _ := http.NewRequest(http.MethodGet, server.URL+target.RequestURI(), nil)
+		req.Host = target.Host
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status %d", test.target, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Values("Content-Type"); !slices.Equal(got, test.want) {
+			t.Errorf("GET %s: content type %q, want %q", test.target, got, test.want)
+		}
+	}
+}
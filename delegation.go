@@ -0,0 +1,79 @@
+package minifed
+
+import (
+	"fmt"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/unixtime"
+)
+
+// delegateTrustMark has owner delegate the issuance of trustMark to e, and lists owner under
+// trust_mark_owners in the entity configurations of the trust anchors above e. It must be called
+// before e issues the trust mark, so that the delegation is carried by the trust marks issued. It
+// returns the owner as listed by the trust anchors.
+func (e *Entity) delegateTrustMark(owner *Entity, trustMark TrustMarkConfig) (*oidcfed.TrustMarkOwnerSpec, error) {
+	tmo := oidcfed.NewTrustMarkOwner(
+		owner.Identifier.String(),
+		owner.FedEntity.GeneralJWTSigner.TrustMarkDelegationSigner(),
+		[]oidcfed.OwnedTrustMark{{
+			ID:                 trustMark.ID,
+			DelegationLifetime: time.Duration(trustMark.DelegationLifetime * float64(time.Second)),
+		}},
+	)
+	delegation, err := tmo.DelegationJWT(trustMark.ID, e.Identifier.String())
+	if err == nil {
+		delegation, err = owner.resign(delegation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s -> %s: %w", owner.Name, e.Name, err)
+	}
+	e.FedEntity.AddTrustMark(oidcfed.TrustMarkSpec{
+		ID:            trustMark.ID,
+		Lifetime:      unixtime.NewDurationInSeconds(trustMark.Lifetime),
+		DelegationJWT: string(delegation),
+	})
+
+	spec := oidcfed.TrustMarkOwnerSpec{
+		ID:   owner.Identifier.String(),
+		JWKS: owner.FedEntity.EntityConfigurationPayload().JWKS,
+	}
+	for _, anchor := range e.trustAnchors() {
+		if anchor.External {
+			// Its trust_mark_owners are up to whoever serves it.
+			continue
+		}
+		if anchor.FedEntity.TrustMarkOwners == nil {
+			anchor.FedEntity.TrustMarkOwners = oidcfed.TrustMarkOwners{}
+		}
+		if existing, ok := anchor.FedEntity.TrustMarkOwners[trustMark.ID]; ok && existing.ID != spec.ID {
+			return nil, fmt.Errorf(
+				"%s: trust mark %s is owned by both %s and %s", anchor.Name, trustMark.ID, existing.ID, spec.ID,
+			)
+		}
+		anchor.FedEntity.TrustMarkOwners[trustMark.ID] = spec
+	}
+	return &spec, nil
+}
+
+// trustAnchors returns the trust anchors reachable by following the superiors of e, including e
+// itself if it's one.
+func (e *Entity) trustAnchors() []*Entity {
+	var anchors []*Entity
+	seen := map[*Entity]bool{}
+	var visit func(*Entity)
+	visit = func(entity *Entity) {
+		if seen[entity] {
+			return
+		}
+		seen[entity] = true
+		if entity.Kind == EntityKindTrustAnchor {
+			anchors = append(anchors, entity)
+		}
+		for _, superior := range entity.Superiors {
+			visit(superior)
+		}
+	}
+	visit(e)
+	return anchors
+}
@@ -0,0 +1,74 @@
+package minifed
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTrustMarkDelegation(t *testing.T) {
+	const id = "https://tm.example.com/certified"
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  TMI:
+    kind: intermediate
+    identifier: https://tmi.example.com
+    trust_marks:
+      - id: `+id+`
+        subjects: [L]
+        owner: OWN
+  OWN:
+    kind: leaf
+    identifier: https://own.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> TMI
+  - TA -> OWN
+  - TA -> L
+`)
+	_, leaf := entityConfiguration(t, handler, "https://l.example.com")
+	trustMarks, _ := leaf["trust_marks"].([]any)
+	if len(trustMarks) != 1 {
+		t.Fatalf("L has %d trust marks, want 1", len(trustMarks))
+	}
+	trustMark, _ := trustMarks[0].(map[string]any)["trust_mark"].(string)
+	_, issuer := entityConfiguration(t, handler, "https://tmi.example.com")
+	payload, err := verifyJWT([]byte(trustMark), claimJSON(t, issuer["jwks"]))
+	if err != nil {
+		t.Fatalf("trust mark doesn't verify with the keys of its issuer: %s", err)
+	}
+	claims := decodeJWT(t, []byte(trustMark))
+	if claims["iss"] != "https://tmi.example.com" || claims["sub"] != "https://l.example.com" || claims["id"] != id {
+		t.Errorf("trust mark claims are %s", payload)
+	}
+
+	// The delegation is signed by the owner, with the keys that the trust anchor lists for it.
+	delegation, _ := claims["delegation"].(string)
+	_, anchor := entityConfiguration(t, handler, "https://ta.example.com")
+	owners, _ := anchor["trust_mark_owners"].(map[string]any)
+	owner, _ := owners[id].(map[string]any)
+	if owner["sub"] != "https://own.example.com" {
+		t.Fatalf("trust anchor lists %v as the owner of %s", owner["sub"], id)
+	}
+	if _, err := verifyJWT([]byte(delegation), claimJSON(t, owner["jwks"])); err != nil {
+		t.Fatalf("delegation doesn't verify with the keys the trust anchor lists for the owner: %s", err)
+	}
+	delegated := decodeJWT(t, []byte(delegation))
+	if delegated["iss"] != "https://own.example.com" || delegated["sub"] != "https://tmi.example.com" || delegated["id"] != id {
+		t.Errorf("delegation claims are %v", delegated)
+	}
+
+	// The resolve endpoint checks the delegation against the trust_mark_owners of the trust anchor,
+	// and leaves out the trust marks that it can't verify.
+	resp := get(t, handler, "https://ta.example.com/resolve?sub=https://l.example.com&trust_anchor=https://ta.example.com")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("resolve: status %d: %s", resp.Code, resp.Body)
+	}
+	if resolved, _ := decodeJWT(t, resp.Body.Bytes())["trust_marks"].([]any); len(resolved) != 1 {
+		t.Errorf("resolve response has %d verified trust marks, want 1", len(resolved))
+	}
+}
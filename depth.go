@@ -0,0 +1,75 @@
+package minifed
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// chainDepth is the range of the number of edges on the paths from an entity up to the trust
+// anchors above it, which is one more than the number of intermediates in between.
+type chainDepth struct {
+	min, max int
+}
+
+// chainDepths returns the chainDepth of each entity that can reach a trust anchor by following
+// its superiors. A trust anchor ends a chain, even if it has superiors of its own. Superiors that
+// lead back around a cycle are skipped.
+func chainDepths(entities map[string]*Entity) map[*Entity]chainDepth {
+	depths := map[*Entity]chainDepth{}
+	visiting := map[*Entity]bool{}
+	var visit func(*Entity) (chainDepth, bool)
+	visit = func(entity *Entity) (chainDepth, bool) {
+		if depth, ok := depths[entity]; ok {
+			return depth, true
+		}
+		if entity.Kind == EntityKindTrustAnchor {
+			return chainDepth{}, true
+		}
+		if visiting[entity] {
+			return chainDepth{}, false
+		}
+		visiting[entity] = true
+		defer delete(visiting, entity)
+
+		var depth chainDepth
+		reachable := false
+		for _, superior := range entity.Superiors {
+			above, ok := visit(superior)
+			if !ok {
+				continue
+			}
+			if !reachable || above.min+1 < depth.min {
+				depth.min = above.min + 1
+			}
+			depth.max = max(depth.max, above.max+1)
+			reachable = true
+		}
+		if reachable {
+			depths[entity] = depth
+		}
+		return depth, reachable
+	}
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		visit(entities[name])
+	}
+	return depths
+}
+
+// reportChainDepths logs the depth of the trust chains from each leaf, and warns about the leaves
+// that can't reach any trust anchor, which would only fail once resolved.
+func reportChainDepths(entities map[string]*Entity) {
+	depths := chainDepths(entities)
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		entity := entities[name]
+		if entity.Kind != EntityKindLeaf {
+			continue
+		}
+		depth, ok := depths[entity]
+		if !ok {
+			slog.Warn("leaf can't reach any trust anchor through its superiors", "entity", name)
+			continue
+		}
+		slog.Info("trust chain depth", "entity", name, "min", depth.min, "max", depth.max)
+	}
+}
@@ -0,0 +1,111 @@
+package minifed
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// runDiff implements the diff subcommand, which prints how the federation described by the second
+// configuration file differs from the one described by the first: the entities added and
+// removed, the entities whose kind or identifier changed, and the edges added, removed, or
+// changed. It reports whether there are any differences.
+func runDiff(args []string) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("usage: minifed diff old.yaml new.yaml")
+	}
+	var configs [2]Config
+	for i, filename := range args {
+		config, err := ParseConfig(filename)
+		if err != nil {
+			return false, err
+		}
+		if err := config.validate(); err != nil {
+			return false, fmt.Errorf("%s: %w", filename, err)
+		}
+		configs[i] = config
+	}
+	return printConfigDiff(os.Stdout, configs[0], configs[1])
+}
+
+// printConfigDiff writes the differences between the validated configurations from and to to w, a
+// line each, and reports whether there are any.
+func printConfigDiff(w io.Writer, from, to Config) (bool, error) {
+	oldEdges, err := normalizeEdges(from.Edges)
+	if err != nil {
+		return false, err
+	}
+	newEdges, err := normalizeEdges(to.Edges)
+	if err != nil {
+		return false, err
+	}
+
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(from.Entities)) {
+		before := from.Entities[name]
+		after, ok := to.Entities[name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("- entity %s (%s, %s)", name, before.Kind, before.Identifier))
+			continue
+		}
+		if before.Kind != after.Kind {
+			lines = append(lines, fmt.Sprintf("~ entity %s: kind %s -> %s", name, before.Kind, after.Kind))
+		}
+		if before.Identifier != after.Identifier {
+			lines = append(lines, fmt.Sprintf("~ entity %s: identifier %s -> %s", name, before.Identifier, after.Identifier))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(to.Entities)) {
+		if _, ok := from.Entities[name]; !ok {
+			after := to.Entities[name]
+			lines = append(lines, fmt.Sprintf("+ entity %s (%s, %s)", name, after.Kind, after.Identifier))
+		}
+	}
+
+	for _, edge := range slices.Sorted(maps.Keys(oldEdges)) {
+		after, ok := newEdges[edge]
+		if !ok {
+			lines = append(lines, "- edge "+edge)
+			continue
+		}
+		if !reflect.DeepEqual(oldEdges[edge], after) {
+			lines = append(lines, fmt.Sprintf("~ edge %s: options changed", edge))
+		}
+	}
+	for _, edge := range slices.Sorted(maps.Keys(newEdges)) {
+		if _, ok := oldEdges[edge]; !ok {
+			lines = append(lines, "+ edge "+edge)
+		}
+	}
+
+	if len(lines) == 0 {
+		fmt.Fprintln(w, "no differences")
+		return false, nil
+	}
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return true, nil
+}
+
+// normalizeEdges splits chains of edges into the relationships they're made of, keyed by
+// "superior -> subordinate". The options of each relationship are kept, less the federation it
+// was declared in, which only matters for validation.
+func normalizeEdges(edges []Edge) (map[string]Edge, error) {
+	normalized := map[string]Edge{}
+	for index, edge := range edges {
+		names, err := parseEdge(edge.Edge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid edge %d %q: %w", index, edge.Edge, err)
+		}
+		for i := 0; i < len(names)-1; i++ {
+			key := names[i] + " -> " + names[i+1]
+			edge.Edge, edge.federation = key, ""
+			normalized[key] = edge
+		}
+	}
+	return normalized, nil
+}
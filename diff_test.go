@@ -0,0 +1,72 @@
+package minifed
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := writeTestConfig(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+  OLD:
+    kind: leaf
+    identifier: https://old.example.com
+edges:
+  - TA -> IM -> L
+  - TA -> OLD
+`)
+	to := writeTestConfig(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  IM:
+    kind: trust-anchor
+    identifier: https://im.example.com
+  L:
+    kind: leaf
+    identifier: https://leaf.example.com
+  NEW:
+    kind: leaf
+    identifier: https://new.example.com
+edges:
+  - edge: IM -> L
+    max_path_length: 1
+  - IM -> NEW
+`)
+	var changed bool
+	var err error
+	output := captureStdout(t, func() { changed, err = runDiff([]string{from, to}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `~ entity IM: kind intermediate -> trust-anchor
+~ entity L: identifier https://l.example.com -> https://leaf.example.com
+- entity OLD (leaf, https://old.example.com)
++ entity NEW (leaf, https://new.example.com)
+~ edge IM -> L: options changed
+- edge TA -> IM
+- edge TA -> OLD
++ edge IM -> NEW
+`
+	if !changed || output != want {
+		t.Errorf("diff reports changes %t:\n%s\nwant:\n%s", changed, output, want)
+	}
+
+	// A configuration doesn't differ from itself, and the exit status says so.
+	output = captureStdout(t, func() { changed, err = runDiff([]string{from, from}) })
+	if err != nil || changed || output != "no differences\n" {
+		t.Errorf("diff of a config with itself reports changes %t, err %v:\n%s", changed, err, output)
+	}
+	if _, err := runDiff([]string{from}); err == nil {
+		t.Error("diffed a single config")
+	}
+}
@@ -0,0 +1,167 @@
+package minifed
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/constants"
+)
+
+// extraMetadata returns the metadata of the entity that go-oidfed has no type for, keyed by
+// entity type.
+func (e *Entity) extraMetadata() map[string]any {
+	extra := map[string]any{}
+	if e.ACMEProvider {
+		extra["acme_provider"] = acmeDirectory(e.Identifier.String())
+	}
+	return extra
+}
+
+// entityConfigurationPayload returns the payload of the entity configuration of e.
+func (e *Entity) entityConfigurationPayload() (*oidcfed.EntityStatementPayload, error) {
+	payload := e.FedEntity.EntityConfigurationPayload()
+	payload.CriticalExtensions = e.Crit
+
+	payload.Extra = map[string]any{}
+	if extra := e.extraMetadata(); len(extra) > 0 || len(e.Metadata) > 0 {
+		// oidcfed.Metadata can't hold arbitrary entity types, so merge them into the metadata claim
+		// through the payload's extra claims, which take precedence when the payload is marshaled.
+		encoded, err := json.Marshal(payload.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata := map[string]any{}
+		if err := json.Unmarshal(encoded, &metadata); err != nil {
+			return nil, err
+		}
+		for entityType, value := range extra {
+			metadata[entityType] = value
+		}
+		for entityType, parameters := range e.Metadata {
+			merged, _ := metadata[entityType].(map[string]any)
+			if merged == nil {
+				merged = map[string]any{}
+			}
+			for name, value := range parameters {
+				merged[name] = value
+			}
+			metadata[entityType] = merged
+		}
+		payload.Extra["metadata"] = metadata
+	}
+	if len(e.TrustMarkTypes) > 0 {
+		payload.Extra["trust_mark_types"] = e.TrustMarkTypes
+	}
+	for name, value := range e.Claims {
+		payload.Extra[name] = value
+	}
+	return payload, nil
+}
+
+// checkCriticalClaims checks that the claims e marks as critical are present in its entity
+// configuration.
+func (e *Entity) checkCriticalClaims() error {
+	if len(e.Crit) == 0 {
+		return nil
+	}
+	payload, err := e.entityConfigurationPayload()
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	claims := map[string]json.RawMessage{}
+	if err := json.Unmarshal(encoded, &claims); err != nil {
+		return err
+	}
+	for _, name := range e.Crit {
+		if _, ok := claims[name]; !ok {
+			return fmt.Errorf("critical claim %s is not in the entity configuration", name)
+		}
+	}
+	return nil
+}
+
+// signedEntityConfiguration is an entity configuration as it was last signed.
+type signedEntityConfiguration struct {
+	mu      sync.Mutex
+	jwt     []byte
+	payload *oidcfed.EntityStatementPayload
+}
+
+// signedEntityConfiguration returns the signed entity configuration of e, signing it on first
+// use. It's served as is until refreshed, so that its iat and exp stay put between requests.
+func (e *Entity) signedEntityConfiguration() ([]byte, error) {
+	e.configuration.mu.Lock()
+	defer e.configuration.mu.Unlock()
+	if e.configuration.jwt == nil {
+		if _, err := e.resignEntityConfiguration(); err != nil {
+			return nil, err
+		}
+	}
+	return e.configuration.jwt, nil
+}
+
+// refreshEntityConfiguration signs the entity configuration of e anew, with fresh timestamps, and
+// returns its payload.
+func (e *Entity) refreshEntityConfiguration() (*oidcfed.EntityStatementPayload, error) {
+	e.configuration.mu.Lock()
+	defer e.configuration.mu.Unlock()
+	return e.resignEntityConfiguration()
+}
+
+// resignEntityConfiguration is refreshEntityConfiguration, with the lock held.
+func (e *Entity) resignEntityConfiguration() (*oidcfed.EntityStatementPayload, error) {
+	payload, err := e.entityConfigurationPayload()
+	if err != nil {
+		return nil, err
+	}
+	jwt, err := e.FedEntity.SignEntityStatement(*payload)
+	if err == nil {
+		jwt, err = e.resign(jwt)
+	}
+	if err == nil && e.FaultSigningKey != nil {
+		jwt, err = e.signWithFaultAlg(jwt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.configuration.jwt, e.configuration.payload = jwt, payload
+	return payload, nil
+}
+
+// refreshEvery refreshes the entity configuration of e at every interval, until e is closed.
+func (e *Entity) refreshEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		}
+		if _, err := e.refreshEntityConfiguration(); err != nil {
+			slog.Error("failed to refresh entity configuration", "entity", e.Name, "err", err)
+		}
+	}
+}
+
+// entityConfigurationHandler serves the signed entity configuration of entity.
+func entityConfigurationHandler(entity *Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jwt, err := entity.signedEntityConfiguration()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", constants.ContentTypeEntityStatement)
+		_, _ = w.Write(jwt)
+	}
+}
@@ -0,0 +1,939 @@
+package minifed
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/fedentities"
+	"github.com/zachmann/go-oidfed/pkg/fedentities/storage"
+	"github.com/zachmann/go-oidfed/pkg/unixtime"
+)
+
+type Entity struct {
+	Superiors    []*Entity
+	Subordinates []*Entity
+	// SubordinateEdges are the edges to each subordinate, keyed by subordinate name.
+	SubordinateEdges  map[string]Edge
+	Name              string
+	Kind              EntityKind
+	Identifier        *url.URL
+	Endpoints         Endpoints
+	TrustMarks        []TrustMarkConfig
+	OpenIDProvider    bool
+	WebFinger         bool
+	ACMEProvider      bool
+	JWKSURI           bool
+	SignedJWKSURI     bool
+	ContentTypes      map[string]string
+	FederationEntity  FederationEntityConfig
+	Metadata          map[string]map[string]any
+	Claims            map[string]any
+	Crit              []string
+	TrustMarkIssuers  map[string][]string
+	TrustMarkTypes    []string
+	SigningPrivateKey crypto.Signer
+	FedEntity         *fedentities.FedEntity
+	Storage           *storage.BadgerStorage
+	// TrustMarkStorage records which entities hold trust marks issued by this entity. It's kept
+	// in its own database, since go-oidfed stores subordinates and trust marked entities under
+	// the same key prefix and listing subordinates trips over the trust mark records.
+	TrustMarkStorage *storage.BadgerStorage
+	// ResolveCache caches the responses of the resolve endpoint, if it's enabled.
+	ResolveCache    *resolveCache
+	RefreshInterval time.Duration
+	Key             KeyConfig
+	// OtherKeys are published in the JWKS of the entity alongside its signing key.
+	OtherKeys   []publishedKey
+	ClockOffset time.Duration
+	// External is set on entities that minifed doesn't serve, which have no keys or FedEntity,
+	// and are only referred to by their identifier.
+	External bool
+	// FaultSigningKey signs the entity configuration in place of SigningPrivateKey, with the
+	// fault_alg of Key.
+	FaultSigningKey crypto.Signer
+
+	configuration signedEntityConfiguration
+	statusFaults  statusFaults
+	statements    issuedStatements
+	// loopback are the routes of the federation of the entity, once they're registered with
+	// loopback.
+	loopback *loopbackRoutes
+	// done is closed once the entity is no longer served.
+	done chan struct{}
+}
+
+func (e *Entity) String() string {
+	var superiors []string
+	for _, superior := range e.Superiors {
+		superiors = append(superiors, superior.Name)
+	}
+	var subordinates []string
+	for _, subordinate := range e.Subordinates {
+		subordinates = append(subordinates, subordinate.Name)
+	}
+	return fmt.Sprintf("EntityNode{Superiors:%+v, Subordinates:%+v, Name:%s, Kind:%s, Identifier:%s}", superiors, subordinates, e.Name, e.Kind, e.Identifier)
+}
+
+// isAuthority reports whether e can have subordinates.
+func (e *Entity) isAuthority() bool {
+	return e.Kind == EntityKindIntermediate || e.Kind == EntityKindTrustAnchor
+}
+
+// endpointPaths returns the paths of the endpoints served by the entity, keyed by endpoint name.
+func (e *Entity) endpointPaths() map[string]string {
+	paths := e.availableEndpointPaths()
+	for _, endpoint := range e.Endpoints.Disabled {
+		delete(paths, endpoint)
+	}
+	return paths
+}
+
+// serves reports whether the entity serves the named endpoint.
+func (e *Entity) serves(endpoint string) bool {
+	_, ok := e.endpointPaths()[endpoint]
+	return ok
+}
+
+// availableEndpointPaths is endpointPaths, including the endpoints that are disabled.
+func (e *Entity) availableEndpointPaths() map[string]string {
+	paths := map[string]string{"well_known": e.Endpoints.WellKnown}
+	if e.isAuthority() {
+		paths["fetch"] = e.Endpoints.Fetch
+		paths["list"] = e.Endpoints.List
+		paths["resolve"] = e.Endpoints.Resolve
+	}
+	if len(e.TrustMarks) > 0 {
+		paths["trust_mark"] = e.Endpoints.TrustMark
+		paths["trust_mark_status"] = e.Endpoints.TrustMarkStatus
+		paths["trust_mark_list"] = e.Endpoints.TrustMarkList
+	}
+	if e.WebFinger {
+		paths["webfinger"] = WebFingerPath
+	}
+	if e.ACMEProvider {
+		paths["acme"] = ACMEPathPrefix
+	}
+	if e.JWKSURI {
+		paths["jwks"] = e.Endpoints.JWKS
+	}
+	if e.SignedJWKSURI {
+		paths["signed_jwks"] = e.Endpoints.SignedJWKS
+	}
+	return paths
+}
+
+// close stops the background work of e, such as refreshing its entity configuration, and closes
+// its storage. It's for once e is no longer served.
+func (e *Entity) close() error {
+	close(e.done)
+	var errs []error
+	for _, store := range []*storage.BadgerStorage{e.Storage, e.TrustMarkStorage} {
+		if store != nil {
+			errs = append(errs, store.DB.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeEntities closes each of entities, logging the errors, and stops serving the requests
+// that go-oidfed makes to them.
+func closeEntities(entities map[string]*Entity) {
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		if routes := entities[name].loopback; routes != nil {
+			loopback.unregister(routes)
+		}
+		if err := entities[name].close(); err != nil {
+			slog.Error("failed to close entity", "entity", name, "err", err)
+		}
+	}
+}
+
+// ready reports whether e has been fully set up, so that other entities can rely on it.
+func (e *Entity) ready() error {
+	if e.FedEntity == nil {
+		return errors.New("federation entity was not created")
+	}
+	if jwks := e.FedEntity.EntityConfigurationPayload().JWKS; jwks.Set == nil || jwks.Len() == 0 {
+		return errors.New("entity has no keys")
+	}
+	if e.isAuthority() && e.Storage == nil {
+		return errors.New("authority has no subordinate storage")
+	}
+	if len(e.TrustMarks) > 0 && e.TrustMarkStorage == nil {
+		return errors.New("trust mark issuer has no trust mark storage")
+	}
+	return nil
+}
+
+// inParallel calls f with each index below n, at most one per CPU at once, and waits for all of
+// the calls to return.
+func inParallel(n int, f func(i int)) {
+	limit := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+			f(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// BuildFederation sets up the entities described by cfg, and returns them keyed by name along
+// with the handler that serves them. Requests are routed to entities by their Host header.
+//
+// Nothing is bound to a port, so the handler can be driven in process, e.g. with httptest. The
+// requests that entities make to each other while resolving trust chains are served by the
+// handler too, until the entities are closed. That goes through go-oidfed's HTTP client, which is
+// shared by the whole process, so several federations can be resolved against at once only if
+// they serve different hosts, see loopbackTransport.
+func BuildFederation(cfg Config) (map[string]*Entity, http.Handler, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+	slog.Debug("read config", slog.Any("config", cfg))
+
+	entities, err := buildEntityGraph(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The storage of entities that were set up is closed again if the federation can't be built,
+	// so that it can be opened anew.
+	built := false
+	defer func() {
+		if !built {
+			closeEntities(entities)
+		}
+	}()
+	if err := checkTrustMarkTypes(entities); err != nil {
+		return nil, nil, err
+	}
+	reportChainDepths(entities)
+
+	// The federation is built in two phases. First every entity is set up on its own, and then,
+	// once all of them are ready, the entities are related to each other, which relies on the
+	// entity configurations of the others. Entities are visited in order of name throughout, so
+	// the outcome doesn't depend on map iteration order.
+	names := slices.Sorted(maps.Keys(entities))
+	// External entities are only referred to by the others, so there's nothing to set up for them.
+	names = slices.DeleteFunc(names, func(name string) bool { return entities[name].External })
+
+	mux := http.NewServeMux()
+	pathRouting := cfg.PathRouting != ""
+	routes := &loopbackRoutes{
+		hosts:       map[string]bool{},
+		pathRouting: pathRouting,
+		next:        newRetryTransport(cfg.Retry, http.DefaultTransport),
+	}
+	servedBy := map[string]*Entity{}
+	// Entities that fail to be set up are reported all at once, rather than one per run.
+	var errs []error
+	// Each entity is set up on its own, so they're set up concurrently, and then registered in
+	// order.
+	entityMuxes := make([]http.Handler, len(names))
+	setupErrs := make([]error, len(names))
+	inParallel(len(names), func(i int) {
+		entity := entities[names[i]]
+		if cfg.ResolveCacheTTL > 0 && entity.isAuthority() {
+			entity.ResolveCache = newResolveCache(cfg.ResolveCacheTTL)
+		}
+		entityMuxes[i], setupErrs[i] = setupEntity(entity, cfg.Storage)
+	})
+	for i, name := range names {
+		entity, entityMux := entities[name], entityMuxes[i]
+		if err := setupErrs[i]; err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entity.Name, err))
+			continue
+		}
+		route := entity.route(pathRouting)
+		if other, ok := servedBy[route]; ok || route == "" {
+			// The entity can't be told apart from another by its route, so leave it unserved.
+			// checkReachable reports it if it's relied upon.
+			if other != nil {
+				slog.Warn("entity is not served, its route is served by another", "entity", entity.Name, "route", route, "served_by", other.Name)
+			} else {
+				slog.Warn("entity is not served, its identifier has no host", "entity", entity.Name)
+			}
+			continue
+		}
+
+		host := routeHost(entity.Identifier.Host) // n.b. the port number is ignored
+		// The endpoints of the entity are served under the path of its identifier, if it has one,
+		// which is the whole route in path routing mode.
+		if path := entity.routePath(); path != "" {
+			mux.Handle(route+"/", stripPrefix(path, entityMux))
+		} else {
+			mux.Handle(route+"/", entityMux)
+		}
+		routes.hosts[routes.key(entity.Identifier.Host)] = true
+		servedBy[route] = entity
+		slog.Info(
+			"registered entity",
+			"host", host,
+			"route", route,
+			"endpoints", entity.endpointPaths(),
+		)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	mux.Handle("/", routingMissHandler(servedBy, pathRouting))
+	if err := checkReachable(entities, names, servedBy, pathRouting, cfg.Unreachable == "warn"); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		if err := entities[name].ready(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: not ready: %w", name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	// Issue trust marks before seeding trust, so that they're present in the entity configurations
+	// as soon as the federation starts serving.
+	if err := issueTrustMarks(entities, names); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		if err := entities[name].checkCriticalClaims(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	if err := seedTrust(entities, names, cfg.SeedTrust == nil || *cfg.SeedTrust); err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range names {
+		if entity := entities[name]; entity.RefreshInterval > 0 {
+			go entity.refreshEvery(entity.RefreshInterval)
+		}
+	}
+
+	var handler http.Handler = mux
+	if !pathRouting {
+		handler = hostRouting(mux)
+	}
+	handler = traceHandler(handler)
+	routes.handler = handler
+	for _, name := range names {
+		entities[name].loopback = routes
+	}
+	loopback.register(routes)
+	built = true
+	return entities, handler, nil
+}
+
+// checkIdentifier checks that identifier is an absolute http or https URL, so that the entity can
+// be routed to by its host.
+func checkIdentifier(identifier *url.URL, requireHTTPS bool) error {
+	switch identifier.Scheme {
+	case "https":
+	case "http":
+		if requireHTTPS {
+			return fmt.Errorf("%s: scheme must be https", identifier)
+		}
+	case "":
+		return fmt.Errorf("%s: scheme must be present", identifier)
+	default:
+		return fmt.Errorf("%s: unsupported scheme %s", identifier, identifier.Scheme)
+	}
+	if identifier.Hostname() == "" {
+		return fmt.Errorf("%s: host must be present", identifier)
+	}
+	return nil
+}
+
+// buildEntityGraph creates an entity for each one in cfg, and links them along its edges.
+func buildEntityGraph(cfg Config) (map[string]*Entity, error) {
+	// Generating keys takes most of the time, so they're generated (or loaded) up front,
+	// concurrently.
+	var cache *keyCache
+	if cfg.KeyCache != "" {
+		var err error
+		if cache, err = openKeyCache(cfg.KeyCache); err != nil {
+			return nil, err
+		}
+	}
+	names := slices.Sorted(maps.Keys(cfg.Entities))
+	keyConfigs := make([][]KeyConfig, len(names))
+	signers := make([][]crypto.Signer, len(names))
+	keyErrs := make([]error, len(names))
+	inParallel(len(names), func(i int) {
+		if cfg.Entities[names[i]].External {
+			return
+		}
+		keyConfigs[i] = cfg.entityKeys(cfg.Entities[names[i]])
+		for j, key := range keyConfigs[i] {
+			var signer crypto.Signer
+			var err error
+			if key.File != "" {
+				signer, err = loadSigningKey(key.File, key.Alg)
+			} else if cache != nil {
+				signer, err = cache.signingKey(names[i], j, key)
+			} else {
+				signer, err = generateSigningKey(key.Alg)
+			}
+			if err != nil {
+				keyErrs[i] = err
+				return
+			}
+			signers[i] = append(signers[i], signer)
+		}
+	})
+	if cache != nil {
+		if err := cache.save(); err != nil {
+			return nil, err
+		}
+	}
+	// Keys that fail to be generated or loaded are reported all at once, rather than one per run.
+	var errs []error
+	for i, name := range names {
+		if err := keyErrs[i]; err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	entityNodes := map[string]*Entity{}
+	for i, name := range names {
+		entityConfig := cfg.Entities[name]
+		if entityConfig.External {
+			identifier, err := url.Parse(entityConfig.Identifier)
+			if err != nil {
+				return nil, fmt.Errorf("invalid url for node %s: %w", name, err)
+			}
+			if err := checkIdentifier(identifier, cfg.RequireHTTPS); err != nil {
+				return nil, fmt.Errorf("invalid url for node %s: %w", name, err)
+			}
+			entityNodes[name] = &Entity{
+				Name:             name,
+				Kind:             entityConfig.Kind,
+				Identifier:       identifier,
+				External:         true,
+				SubordinateEdges: map[string]Edge{},
+				done:             make(chan struct{}),
+			}
+			continue
+		}
+		if cfg.PathRouting != "" {
+			var err error
+			if entityConfig.Identifier, err = pathRoutingIdentifier(cfg.PathRouting, name); err != nil {
+				return nil, fmt.Errorf("invalid path routing url for node %s: %w", name, err)
+			}
+		}
+		identifier, err := url.Parse(entityConfig.Identifier)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url for node %s: %w", name, err)
+		}
+		if err := checkIdentifier(identifier, cfg.RequireHTTPS); err != nil {
+			return nil, fmt.Errorf("invalid url for node %s: %w", name, err)
+		}
+		key, signingKey := keyConfigs[i][0], signers[i][0]
+		var otherKeys []publishedKey
+		for j, other := range keyConfigs[i][1:] {
+			otherKeys = append(otherKeys, publishedKey{other, signers[i][j+1]})
+		}
+		slog.Debug("generated signing key", "entity", name, "alg", key.Alg, "other_keys", len(otherKeys))
+		entityNodes[name] = &Entity{
+			Name:              name,
+			Kind:              entityConfig.Kind,
+			Identifier:        identifier,
+			Endpoints:         entityConfig.Endpoints,
+			TrustMarks:        entityConfig.TrustMarks,
+			OpenIDProvider:    entityConfig.OpenIDProvider,
+			WebFinger:         entityConfig.WebFinger,
+			ACMEProvider:      entityConfig.ACMEProvider,
+			JWKSURI:           entityConfig.JWKSURI,
+			SignedJWKSURI:     entityConfig.SignedJWKSURI,
+			ContentTypes:      entityConfig.ContentTypes,
+			FederationEntity:  entityConfig.FederationEntity,
+			Metadata:          entityConfig.Metadata,
+			Claims:            entityConfig.Claims,
+			Crit:              entityConfig.Crit,
+			TrustMarkIssuers:  entityConfig.TrustMarkIssuers,
+			TrustMarkTypes:    entityConfig.TrustMarkTypes,
+			RefreshInterval:   entityConfig.RefreshInterval,
+			Key:               key,
+			OtherKeys:         otherKeys,
+			ClockOffset:       entityConfig.ClockOffset,
+			SubordinateEdges:  map[string]Edge{},
+			SigningPrivateKey: signingKey,
+			done:              make(chan struct{}),
+		}
+		entityNodes[name].statusFaults.faults = maps.Clone(entityConfig.StatusFaults)
+		available := entityNodes[name].availableEndpointPaths()
+		for _, endpoint := range entityConfig.Endpoints.Disabled {
+			if _, ok := available[endpoint]; !ok || endpoint == "well_known" {
+				return nil, fmt.Errorf("%s: can't disable %s, which the entity doesn't serve", name, endpoint)
+			}
+		}
+		paths := entityNodes[name].endpointPaths()
+		if err := validateEndpointPaths(paths); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for endpoint := range entityConfig.ContentTypes {
+			if _, ok := paths[endpoint]; !ok {
+				return nil, fmt.Errorf("%s: content type given for %s, which the entity doesn't serve", name, endpoint)
+			}
+		}
+		for endpoint := range entityConfig.StatusFaults {
+			if _, ok := paths[endpoint]; !ok {
+				return nil, fmt.Errorf("%s: status fault given for %s, which the entity doesn't serve", name, endpoint)
+			}
+		}
+	}
+
+	for index, edge := range cfg.Edges {
+		names, err := parseEdge(edge.Edge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid edge %d %q: %w", index, edge.Edge, err)
+		}
+		if edge.MaxPathLength < 0 {
+			return nil, fmt.Errorf("invalid edge %d %q: max_path_length must be positive", index, edge.Edge)
+		}
+		if _, err := edge.status(); err != nil {
+			return nil, fmt.Errorf("invalid edge %d %q: %w", index, edge.Edge, err)
+		}
+		if _, _, err := edge.metadataPolicy(); err != nil {
+			return nil, fmt.Errorf("invalid edge %d %q: %w", index, edge.Edge, err)
+		}
+		if _, err := edge.previous(); err != nil {
+			return nil, fmt.Errorf("invalid edge %d %q: %w", index, edge.Edge, err)
+		}
+		for _, name := range names {
+			if _, ok := entityNodes[name]; !ok {
+				return nil, fmt.Errorf("undefined reference to node %s in edge %d %q", name, index, edge.Edge)
+			}
+		}
+
+		for i := 0; i < len(names)-1; i++ {
+			headNode, tailNode := entityNodes[names[i]], entityNodes[names[i+1]]
+			if err := checkEdgeKinds(headNode.Kind, tailNode.Kind, edge.CrossFederation); err != nil {
+				return nil, fmt.Errorf("invalid edge %d %q: %s -> %s: %w", index, edge.Edge, headNode.Name, tailNode.Name, err)
+			}
+			if tailNode.External {
+				return nil, fmt.Errorf("invalid edge %d %q: %s -> %s: external entities can only be superiors, since minifed has no keys to publish for them",
+					index, edge.Edge, headNode.Name, tailNode.Name)
+			}
+			headNode.Subordinates = append(headNode.Subordinates, tailNode)
+			tailNode.Superiors = append(tailNode.Superiors, headNode)
+			headNode.SubordinateEdges[tailNode.Name] = edge
+		}
+	}
+
+	for _, name := range names {
+		if err := entityNodes[name].orderSuperiors(cfg.Entities[name].AuthorityHints); err != nil {
+			return nil, fmt.Errorf("%s: authority_hints: %w", name, err)
+		}
+	}
+
+	for name, node := range entityNodes {
+		if len(node.Superiors) == 0 && len(node.Subordinates) == 0 {
+			slog.Warn("entity is isolated, it is not part of any edge", "entity", name)
+		}
+	}
+
+	slog.Info("parsed entities", "entityNodes", entityNodes)
+	return entityNodes, nil
+}
+
+// orderSuperiors sorts the superiors of e, which its authority hints are listed in the order of,
+// so that they don't depend on the order that edges are declared in. The superiors named in
+// precedence come first, in that order, and the rest follow in order of identifier.
+func (e *Entity) orderSuperiors(precedence []string) error {
+	rank := map[string]int{}
+	for i, name := range precedence {
+		if _, ok := rank[name]; ok {
+			return fmt.Errorf("%s is listed more than once", name)
+		}
+		if !slices.ContainsFunc(e.Superiors, func(superior *Entity) bool { return superior.Name == name }) {
+			return fmt.Errorf("%s is not a superior of %s", name, e.Name)
+		}
+		rank[name] = i
+	}
+	slices.SortFunc(e.Superiors, func(a, b *Entity) int {
+		rankA, rankedA := rank[a.Name]
+		rankB, rankedB := rank[b.Name]
+		switch {
+		case rankedA && rankedB:
+			return rankA - rankB
+		case rankedA:
+			return -1
+		case rankedB:
+			return 1
+		}
+		return strings.Compare(a.Identifier.String(), b.Identifier.String())
+	})
+	return nil
+}
+
+// setupEntity creates the federation entity and storage for entity, and returns the handler for
+// its endpoints.
+func setupEntity(entity *Entity, storageConfig StorageConfig) (http.Handler, error) {
+	slog.Debug("starting server for entity", slog.Any("entity", entity))
+	var authorityHints []string
+	for _, authority := range entity.Superiors {
+		authorityHints = append(authorityHints, authority.Identifier.String())
+	}
+
+	// I'm not sure whether this function is correct for starting a leaf entity. There is
+	// oidcfed.NewFederationLeaf() which seems more suitable, but then you have to register your own
+	// HTTP handlers. It _seems_ like fedentity is a higher level package for running a federation
+	// entity, but it feels like it's assuming that you'd only use it when operating a TA or
+	// intermediate, not a leaf.
+	//
+	// Regardless, if we take a fedentity with the correct metadata, we can treat it as a leaf anyway
+	// and get the .well-known/openid-federation handler for free.
+	// go-oidfed only fills in the endpoints of the federation_entity metadata as they're
+	// registered, so the rest of it is left as given here.
+	metadata := &oidcfed.Metadata{
+		FederationEntity: &oidcfed.FederationEntityMetadata{
+			OrganizationName: entity.FederationEntity.OrganizationName,
+			Contacts:         entity.FederationEntity.Contacts,
+			LogoURI:          entity.FederationEntity.LogoURI,
+			HomepageURI:      entity.FederationEntity.HomepageURI,
+		},
+	}
+	if entity.OpenIDProvider {
+		metadata.OpenIDProvider = &oidcfed.OpenIDProviderMetadata{Issuer: entity.Identifier.String()}
+	}
+	if err := entity.setJWKSURIs(metadata); err != nil {
+		return nil, err
+	}
+	fedentity, err := fedentities.NewFedEntity(
+		entity.Identifier.String(),
+		authorityHints,
+		// oidcfed will take care of adding the federation entity metadata when we register the various
+		// federation endpoints
+		metadata,
+		entity.SigningPrivateKey,
+		// This must align with the type of signing key.
+		jwa.SignatureAlgorithm(entity.Key.Alg),
+		60*60*24*365,
+		fedentities.SubordinateStatementsConfig{
+			// Metadata policies are configured per edge rather than here, and recorded with each
+			// subordinate for fetchHandler to include.
+			//
+			// Without a lifetime, subordinate statements expire as soon as they're issued, and fail
+			// resolution.
+			SubordinateStatementLifetime: 60 * 60 * 24 * 365,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	entity.FedEntity = fedentity
+	fedentity.TrustMarkIssuers = entity.TrustMarkIssuers
+	if err := entity.setupJWKS(); err != nil {
+		return nil, err
+	}
+	if alg := entity.Key.FaultAlg; alg != "" {
+		if alg == entity.Key.Alg {
+			return nil, fmt.Errorf("fault_alg %s must differ from the alg of the key", alg)
+		}
+		if entity.FaultSigningKey, err = generateSigningKey(alg); err != nil {
+			return nil, err
+		}
+		slog.Warn("entity configuration is deliberately signed with the wrong algorithm",
+			"entity", entity.Name, "alg", alg, "jwks_alg", entity.Key.Alg)
+	}
+
+	isAuthority := entity.isAuthority()
+	if isAuthority || len(entity.TrustMarks) > 0 {
+		db, err := storageConfig.open(entity, "trust-marks")
+		if err != nil {
+			return nil, err
+		}
+		entity.TrustMarkStorage = db
+	}
+
+	if len(entity.TrustMarks) > 0 {
+		for _, trustMark := range entity.TrustMarks {
+			fedentity.AddTrustMark(oidcfed.TrustMarkSpec{
+				ID:       trustMark.ID,
+				Lifetime: unixtime.NewDurationInSeconds(trustMark.Lifetime),
+			})
+		}
+		trustDb := entity.TrustMarkStorage.TrustMarkedEntitiesStorage()
+		if entity.serves("trust_mark") {
+			fedentity.AddTrustMarkEndpoint(fedentities.EndpointConf{Path: entity.Endpoints.TrustMark}, trustDb, nil)
+		}
+		if entity.serves("trust_mark_status") {
+			fedentity.AddTrustMarkStatusEndpoint(fedentities.EndpointConf{Path: entity.Endpoints.TrustMarkStatus}, trustDb)
+		}
+		if entity.serves("trust_mark_list") {
+			fedentity.AddTrustMarkedEntitiesListingEndpoint(fedentities.EndpointConf{Path: entity.Endpoints.TrustMarkList}, trustDb)
+		}
+	}
+
+	if isAuthority {
+		db, err := storageConfig.open(entity, "subordinates")
+		if err != nil {
+			return nil, err
+		}
+		subDb := filteringSubordinateStorage{db.SubordinateStorage()}
+		trustDb := entity.TrustMarkStorage.TrustMarkedEntitiesStorage()
+
+		if entity.serves("list") {
+			fedentity.AddSubordinateListingEndpoint(fedentities.EndpointConf{Path: entity.Endpoints.List}, subDb, trustDb)
+		}
+		if entity.serves("fetch") {
+			// The fetch endpoint is served by minifed rather than go-oidfed, so that the statements
+			// can carry the parts of SubordinateInfo that go-oidfed leaves out. Registering it
+			// without a path only advertises it in the metadata.
+			fetchURL, err := url.JoinPath(entity.Identifier.String(), entity.Endpoints.Fetch)
+			if err != nil {
+				return nil, err
+			}
+			fedentity.AddFetchEndpoint(fedentities.EndpointConf{URL: fetchURL}, subDb)
+		}
+		if entity.serves("resolve") {
+			// The resolve endpoint is served by minifed rather than go-oidfed too, so that it
+			// checks the constraints of the chains it resolves, see resolveHandler.
+			resolveURL, err := url.JoinPath(entity.Identifier.String(), entity.Endpoints.Resolve)
+			if err != nil {
+				return nil, err
+			}
+			fedentity.AddResolveEndpoint(fedentities.EndpointConf{URL: resolveURL})
+		}
+
+		entity.Storage = db
+	}
+
+	entityMux := http.NewServeMux()
+	// JWTs signed by go-oidfed are signed anew if the key id or clock is overridden.
+	oidfedHandler := resignHandler(entity, fedentity.HttpHandlerFunc())
+	entityMux.Handle("/", oidfedHandler)
+	// The entity configuration is served by minifed rather than go-oidfed, so that it can carry
+	// metadata that go-oidfed doesn't know about. go-oidfed always serves it at the default path,
+	// so hide that if another one is configured.
+	entityMux.Handle(entity.Endpoints.WellKnown, entityConfigurationHandler(entity))
+	if entity.Endpoints.WellKnown != DefaultWellKnownPath {
+		entityMux.Handle(DefaultWellKnownPath, http.NotFoundHandler())
+	}
+	if entity.serves("resolve") {
+		// The entity configurations and statements that this endpoint fetches are served in process
+		// by the loopback transport.
+		resolve := traceHops(resolveHandler(entity))
+		if entity.ResolveCache != nil {
+			resolve = entity.ResolveCache.handler(resolve)
+		}
+		entityMux.Handle(entity.Endpoints.Resolve, logResolvedMetadata(entity, resolve))
+	}
+	if entity.Storage != nil && entity.serves("fetch") {
+		entityMux.Handle(entity.Endpoints.Fetch, fetchHandler(entity))
+	}
+	if entity.WebFinger {
+		entityMux.Handle(WebFingerPath, webFingerHandler(entity.Identifier.String()))
+	}
+	if entity.ACMEProvider {
+		entityMux.Handle(ACMEPathPrefix, acmeHandler(entity.Identifier.String()))
+	}
+	if entity.JWKSURI {
+		entityMux.Handle(entity.Endpoints.JWKS, jwksHandler(entity))
+	}
+	if entity.SignedJWKSURI {
+		entityMux.Handle(entity.Endpoints.SignedJWKS, signedJWKSHandler(entity))
+	}
+	return statusFaultHandler(entity, contentTypeHandler(entityMux, entity.contentTypeOverrides())), nil
+}
+
+// checkReachable checks that each subordinate is served at the route of its identifier, so that
+// its superiors and anyone resolving it can fetch its entity configuration. Unreachable
+// subordinates are an error, unless warnOnly is set, in which case they're logged. Only the
+// superiors among names are checked, which leaves out external ones, since they fetch from
+// outside of minifed.
+func checkReachable(entities map[string]*Entity, names []string, servedBy map[string]*Entity, pathRouting, warnOnly bool) error {
+	var errs []error
+	for _, name := range names {
+		entity := entities[name]
+		for _, subordinate := range entity.Subordinates {
+			route := subordinate.route(pathRouting)
+			if servedBy[route] == subordinate {
+				continue
+			}
+			err := fmt.Errorf("%s -> %s: subordinate is unreachable, nothing serves it at %q", entity.Name, subordinate.Name, route)
+			if other := servedBy[route]; other != nil {
+				err = fmt.Errorf("%s -> %s: subordinate is unreachable, %q is served by %s", entity.Name, subordinate.Name, route, other.Name)
+			}
+			if warnOnly {
+				slog.Warn(err.Error())
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkTrustMarkTypes checks that the trust marks issued by each entity are recognized by the
+// trust anchors above it which declare trust mark types.
+func checkTrustMarkTypes(entities map[string]*Entity) error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		entity := entities[name]
+		for _, trustMark := range entity.TrustMarks {
+			for _, anchor := range entity.trustAnchors() {
+				if len(anchor.TrustMarkTypes) > 0 && !slices.Contains(anchor.TrustMarkTypes, trustMark.ID) {
+					errs = append(errs, fmt.Errorf(
+						"%s: trust mark %s is not among the trust mark types of %s", entity.Name, trustMark.ID, anchor.Name,
+					))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// issueTrustMarks issues the configured trust marks, and adds them to the entity configurations of
+// their subjects. The issuers are visited in the order of names.
+func issueTrustMarks(entities map[string]*Entity, names []string) error {
+	for _, name := range names {
+		entity := entities[name]
+		for _, trustMark := range entity.TrustMarks {
+			var owner *oidcfed.TrustMarkOwnerSpec
+			if trustMark.Owner != "" {
+				var err error
+				if owner, err = entity.delegateTrustMark(entities[trustMark.Owner], trustMark); err != nil {
+					return err
+				}
+			}
+			for _, subjectName := range trustMark.Subjects {
+				subject := entities[subjectName]
+				sub := subject.Identifier.String()
+				if err := entity.TrustMarkStorage.TrustMarkedEntitiesStorage().Approve(trustMark.ID, sub); err != nil {
+					return fmt.Errorf("%s -> %s: %w", entity.Name, subject.Name, err)
+				}
+				info, err := entity.FedEntity.IssueTrustMark(trustMark.ID, sub)
+				if err != nil {
+					return fmt.Errorf("%s -> %s: %w", entity.Name, subject.Name, err)
+				}
+				jwt, err := entity.resign([]byte(info.TrustMarkJWT))
+				if err != nil {
+					return fmt.Errorf("%s -> %s: %w", entity.Name, subject.Name, err)
+				}
+				info.TrustMarkJWT = string(jwt)
+				tmc := &oidcfed.EntityConfigurationTrustMarkConfig{JWT: info.TrustMarkJWT}
+				if err := tmc.Verify(sub, ""); err != nil {
+					return fmt.Errorf("%s -> %s: %w", entity.Name, subject.Name, err)
+				}
+				if owner != nil && entity.ClockOffset == 0 {
+					// Check that the delegation holds up, as a client would with the trust_mark_owners
+					// of the trust anchor. A clock offset can make it fail on purpose.
+					if err := info.VerifyExternal(entity.FedEntity.EntityConfigurationPayload().JWKS, *owner); err != nil {
+						return fmt.Errorf("%s -> %s: %w", entity.Name, subject.Name, err)
+					}
+				}
+				subject.FedEntity.TrustMarks = append(subject.FedEntity.TrustMarks, tmc)
+				slog.Info(
+					"issued trust mark",
+					"issuer", entity.Identifier.String(),
+					"subject", sub,
+					"trust_mark_id", trustMark.ID,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// seedTrust writes a subordinate statement for each edge that's seeded at startup into the
+// storage of the superior, see Edge.seeded. The superiors are visited in the order of names.
+func seedTrust(entities map[string]*Entity, names []string, seed bool) error {
+	for _, name := range names {
+		entity := entities[name]
+		for _, subordinate := range entity.Subordinates {
+			if !entity.SubordinateEdges[subordinate.Name].seeded(seed) {
+				slog.Info(
+					"not seeding trust",
+					"parent", entity.Identifier.String(),
+					"child", subordinate.Identifier.String(),
+				)
+				continue
+			}
+			if err := entity.seedSubordinate(subordinate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seedSubordinate records subordinate in the storage of e, as configured by the edge between them.
+func (e *Entity) seedSubordinate(subordinate *Entity) error {
+	if e.Storage == nil {
+		return fmt.Errorf("%s -> %s: superior has no subordinate storage", e.Name, subordinate.Name)
+	}
+	if subordinate.FedEntity == nil {
+		return fmt.Errorf("%s -> %s: subordinate was not initialized", e.Name, subordinate.Name)
+	}
+	entityConfig := subordinate.FedEntity.EntityConfigurationPayload()
+	if entityConfig == nil || entityConfig.JWKS.Set == nil || entityConfig.JWKS.Len() == 0 {
+		return fmt.Errorf("%s -> %s: subordinate has no keys", e.Name, subordinate.Name)
+	}
+	edge := e.SubordinateEdges[subordinate.Name]
+	status, err := edge.status()
+	if err != nil {
+		return fmt.Errorf("%s -> %s: %w", e.Name, subordinate.Name, err)
+	}
+	metadataPolicy, metadataPolicyCrit, err := edge.metadataPolicy()
+	if err != nil {
+		return fmt.Errorf("%s -> %s: %w", e.Name, subordinate.Name, err)
+	}
+	previous, err := edge.previous()
+	if err != nil {
+		return fmt.Errorf("%s -> %s: %w", e.Name, subordinate.Name, err)
+	}
+	info := storage.SubordinateInfo{
+		JWKS:               entityConfig.JWKS,
+		EntityTypes:        []string{}, // TODO: what should these be?,
+		EntityID:           subordinate.Identifier.String(),
+		Constraints:        edge.constraints(),
+		MetadataPolicy:     metadataPolicy,
+		MetadataPolicyCrit: metadataPolicyCrit,
+		Status:             status,
+	}
+	if err := e.Storage.SubordinateStorage().Write(
+		subordinate.Identifier.String(), info,
+	); err != nil {
+		return fmt.Errorf("%s -> %s: %w", e.Name, subordinate.Name, err)
+	}
+	// The statements about the subordinate are signed anew from the new records when next fetched.
+	// go-oidfed's storage only holds one record per subordinate, so the previous one is kept
+	// alongside the statements.
+	var previousInfo *storage.SubordinateInfo
+	if previous != nil {
+		prior := info
+		previousInfo = &prior
+		previousInfo.Constraints = previous.constraints()
+		previousInfo.MetadataPolicy, previousInfo.MetadataPolicyCrit, _ = previous.metadataPolicy()
+	}
+	e.statements.reset(subordinate.Identifier.String(), previousInfo, edge.PreviousAge)
+	slog.Info(
+		"established trust",
+		"parent", e.Identifier.String(),
+		"child", subordinate.Identifier.String(),
+	)
+	return nil
+}
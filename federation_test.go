@@ -0,0 +1,281 @@
+package minifed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/zachmann/go-oidfed/pkg/cache"
+)
+
+func TestMain(m *testing.M) {
+	// go-oidfed caches the statements it fetches for the whole process, which would carry over
+	// from the federation of one test to the next, whose entities have other keys.
+	cache.SetCache(noCache{})
+	os.Exit(m.Run())
+}
+
+// noCache is a go-oidfed cache that never holds anything.
+type noCache struct{}
+
+func (noCache) Get(string, any) (bool, error)        { return false, nil }
+func (noCache) Set(string, any, time.Duration) error { return nil }
+
+// testChain is a federation of a trust anchor, an intermediate, and a leaf below it.
+const testChain = `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> IM -> L
+`
+
+// writeTestConfig writes config to a configuration file of its own, returning its name.
+func writeTestConfig(t *testing.T, config string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// buildTestFederation builds the federation of the configuration file content config, which is
+// closed once the test is done.
+func buildTestFederation(t *testing.T, config string) (map[string]*Entity, http.Handler) {
+	t.Helper()
+	cfg, err := ParseConfig(writeTestConfig(t, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, handler, err := BuildFederation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeEntities(entities) })
+	return entities, handler
+}
+
+// get serves a GET request for target, routed by its host, with handler.
+func get(t *testing.T, handler http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+// verifyJWT verifies jwt with the key of the JWK set jwks that its kid header names, and returns
+// its payload.
+func verifyJWT(jwt, jwks []byte) ([]byte, error) {
+	set, err := jwk.Parse(jwks)
+	if err != nil {
+		return nil, err
+	}
+	message, err := jws.Parse(jwt)
+	if err != nil {
+		return nil, err
+	}
+	headers := message.Signatures()[0].ProtectedHeaders()
+	key, ok := set.LookupKeyID(headers.KeyID())
+	if !ok {
+		return nil, fmt.Errorf("no key has the kid %q of the header", headers.KeyID())
+	}
+	if key.Algorithm() != headers.Algorithm().String() {
+		return nil, fmt.Errorf("key %s is for %s, not %s", key.KeyID(), key.Algorithm(), headers.Algorithm())
+	}
+	return jws.Verify(jwt, jwa.SignatureAlgorithm(key.Algorithm()), key)
+}
+
+// entityConfiguration returns the entity configuration that handler serves for the entity
+// identified by id, and its payload.
+func entityConfiguration(t *testing.T, handler http.Handler, id string) ([]byte, map[string]any) {
+	t.Helper()
+	resp := get(t, handler, id+"/.well-known/openid-federation")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("entity configuration of %s: status %d: %s", id, resp.Code, resp.Body)
+	}
+	jwt := resp.Body.Bytes()
+	return jwt, decodeJWT(t, jwt)
+}
+
+// decodeJWT returns the payload of jwt, without verifying it.
+func decodeJWT(t *testing.T, jwt []byte) map[string]any {
+	t.Helper()
+	payload, ok := jwtPayload(jwt)
+	if !ok {
+		t.Fatalf("not a JWT: %s", jwt)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+// claimJSON encodes claim, such as the jwks of a payload decoded by decodeJWT, as JSON.
+func claimJSON(t *testing.T, claim any) []byte {
+	t.Helper()
+	if claim == nil {
+		t.Fatal("claim is missing")
+	}
+	encoded, err := json.Marshal(claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestTrustMarkTypes(t *testing.T) {
+	const config = `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    trust_mark_types: [https://tm.example.com/certified]
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+    trust_marks:
+      - id: %s
+        subjects: [L]
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> IM -> L
+`
+	_, handler := buildTestFederation(t, fmt.Sprintf(config, "https://tm.example.com/certified"))
+	_, anchor := entityConfiguration(t, handler, "https://ta.example.com")
+	if types := string(claimJSON(t, anchor["trust_mark_types"])); types != `["https://tm.example.com/certified"]` {
+		t.Errorf("trust_mark_types of TA is %s", types)
+	}
+
+	cfg, err := ParseConfig(writeTestConfig(t, fmt.Sprintf(config, "https://tm.example.com/unknown")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, _, err := BuildFederation(cfg)
+	if err == nil {
+		closeEntities(entities)
+		t.Fatal("built a federation issuing a trust mark its trust anchor doesn't recognize")
+	}
+	if want := "IM: trust mark https://tm.example.com/unknown is not among the trust mark types of TA"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q doesn't mention %q", err, want)
+	}
+}
+
+func TestOrderSuperiors(t *testing.T) {
+	superior := func(name, identifier string) *Entity {
+		return &Entity{Name: name, Identifier: &url.URL{Scheme: "https", Host: identifier}}
+	}
+	superiors := []*Entity{
+		superior("A", "d.example.com"),
+		superior("B", "c.example.com"),
+		superior("C", "b.example.com"),
+		superior("D", "a.example.com"),
+	}
+	for _, test := range []struct {
+		precedence []string
+		want       []string
+	}{
+		// Without authority_hints, the superiors are in order of identifier, not of name.
+		{precedence: nil, want: []string{"D", "C", "B", "A"}},
+		{precedence: []string{"B"}, want: []string{"B", "D", "C", "A"}},
+		{precedence: []string{"A", "C"}, want: []string{"A", "C", "D", "B"}},
+		{precedence: []string{"C", "A", "D", "B"}, want: []string{"C", "A", "D", "B"}},
+	} {
+		// The order doesn't depend on the one the superiors start out in.
+		for i := range superiors {
+			entity := &Entity{Name: "L", Superiors: append(slices.Clone(superiors[i:]), superiors[:i]...)}
+			if err := entity.orderSuperiors(test.precedence); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, superior := range entity.Superiors {
+				got = append(got, superior.Name)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("superiors with precedence %v, starting from %s, are %v, want %v", test.precedence, superiors[i].Name, got, test.want)
+			}
+		}
+	}
+
+	for _, precedence := range [][]string{{"A", "A"}, {"E"}} {
+		entity := &Entity{Name: "L", Superiors: slices.Clone(superiors)}
+		if err := entity.orderSuperiors(precedence); err == nil {
+			t.Errorf("ordered superiors with the precedence %v", precedence)
+		}
+	}
+}
+
+func TestAuthorityHintsOrder(t *testing.T) {
+	hints := func(edges, authorityHints string) string {
+		t.Helper()
+		_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  X:
+    kind: intermediate
+    identifier: https://x.example.com
+  Y:
+    kind: intermediate
+    identifier: https://y.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com`+authorityHints+`
+edges:
+`+edges)
+		_, claims := entityConfiguration(t, handler, "https://l.example.com")
+		return string(claimJSON(t, claims["authority_hints"]))
+	}
+	const forward, backward = "  - TA -> X -> L\n  - TA -> Y -> L\n", "  - TA -> Y -> L\n  - TA -> X -> L\n"
+	for _, edges := range []string{forward, backward} {
+		if got := hints(edges, ""); got != `["https://x.example.com","https://y.example.com"]` {
+			t.Errorf("authority_hints of L are %s without precedence", got)
+		}
+		if got := hints(edges, "\n    authority_hints: [Y]"); got != `["https://y.example.com","https://x.example.com"]` {
+			t.Errorf("authority_hints of L are %s with Y first", got)
+		}
+	}
+}
+
+// captureStdout returns what f writes to os.Stdout, as the subcommands write their output there.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	output := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(r)
+		output <- string(content)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
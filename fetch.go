@@ -0,0 +1,172 @@
+package minifed
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/constants"
+	"github.com/zachmann/go-oidfed/pkg/fedentities/storage"
+)
+
+// fetchHandler serves the fetch endpoint of entity, issuing subordinate statements for the
+// subordinates in its storage. It behaves like go-oidfed's fetch endpoint, except that the constraints
+// and metadata policy recorded for each subordinate are included in its statement, and that the
+// previous version of a statement is served when asked for with version=previous, see
+// Edge.Previous.
+func fetchHandler(entity *Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub := r.URL.Query().Get("sub")
+		if sub == "" {
+			writeJSON(w, http.StatusBadRequest, oidcfed.ErrorInvalidRequest("required parameter 'sub' not given"))
+			return
+		}
+		previous, err := statementVersion(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, oidcfed.ErrorInvalidRequest(err.Error()))
+			return
+		}
+		jwt, err := subordinateStatement(entity, sub, previous)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+			return
+		}
+		if jwt == nil {
+			writeJSON(w, http.StatusNotFound, oidcfed.ErrorNotFound("the requested entity identifier is not found"))
+			return
+		}
+		w.Header().Set("Content-Type", constants.ContentTypeEntityStatement)
+		_, _ = w.Write(jwt)
+	}
+}
+
+// statementVersion reports whether the version query parameter of r asks for the previous
+// version of a subordinate statement, rather than the current one.
+func statementVersion(r *http.Request) (bool, error) {
+	switch version := r.URL.Query().Get("version"); version {
+	case "", "current":
+		return false, nil
+	case "previous":
+		return true, nil
+	default:
+		return false, fmt.Errorf("version must be current or previous, not %q", version)
+	}
+}
+
+// statementKey identifies a subordinate statement among those an entity issues.
+type statementKey struct {
+	sub      string
+	previous bool
+}
+
+// previousStatement is the record that the previous version of a subordinate statement is signed
+// from, and how long before now it's backdated to.
+type previousStatement struct {
+	info storage.SubordinateInfo
+	age  time.Duration
+}
+
+// issuedStatements are the subordinate statements that an entity serves from its fetch endpoint.
+type issuedStatements struct {
+	mu   sync.Mutex
+	jwts map[statementKey][]byte
+	// previous are the records of the previous versions of the statements, by subject.
+	previous map[string]previousStatement
+}
+
+// reset drops the statements about sub, so that they're signed anew when next fetched, and
+// replaces the record of the previous version, if given.
+func (s *issuedStatements) reset(sub string, previous *storage.SubordinateInfo, age time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jwts, statementKey{sub, false})
+	delete(s.jwts, statementKey{sub, true})
+	delete(s.previous, sub)
+	if previous != nil {
+		if s.previous == nil {
+			s.previous = map[string]previousStatement{}
+		}
+		s.previous[sub] = previousStatement{*previous, age}
+	}
+}
+
+// subordinateStatement returns the subordinate statement about sub that entity serves from its
+// fetch endpoint, or its previous version, or nil if there's no such statement. It's signed on
+// first use, and served as is until it expires or is signed anew, so that its iat and exp stay
+// put between requests like those of entity configurations.
+func subordinateStatement(entity *Entity, sub string, previous bool) ([]byte, error) {
+	entity.statements.mu.Lock()
+	defer entity.statements.mu.Unlock()
+	if jwt, ok := entity.statements.jwts[statementKey{sub, previous}]; ok {
+		if exp, ok := jwtExpiry(jwt); ok && time.Now().Before(exp) {
+			return jwt, nil
+		}
+	}
+	return entity.resignSubordinateStatement(sub, previous)
+}
+
+// refreshSubordinateStatement signs the subordinate statement about sub, or its previous version,
+// anew from the current record of e, and returns it, or nil if there's no such statement.
+func (e *Entity) refreshSubordinateStatement(sub string, previous bool) ([]byte, error) {
+	e.statements.mu.Lock()
+	defer e.statements.mu.Unlock()
+	return e.resignSubordinateStatement(sub, previous)
+}
+
+// resignSubordinateStatement is refreshSubordinateStatement, with the lock held.
+func (e *Entity) resignSubordinateStatement(sub string, previous bool) ([]byte, error) {
+	key := statementKey{sub, previous}
+	delete(e.statements.jwts, key)
+	var jwt []byte
+	var err error
+	if previous {
+		if record, ok := e.statements.previous[sub]; ok {
+			jwt, err = signSubordinateStatement(e, &record.info, record.age)
+		}
+	} else {
+		jwt, err = signCurrentSubordinateStatement(e, sub)
+	}
+	if err != nil || jwt == nil {
+		return nil, err
+	}
+	if e.statements.jwts == nil {
+		e.statements.jwts = map[statementKey][]byte{}
+	}
+	e.statements.jwts[key] = jwt
+	return jwt, nil
+}
+
+// signCurrentSubordinateStatement signs the subordinate statement about sub from the record in
+// the storage of entity, or returns nil if sub isn't an active subordinate of entity.
+func signCurrentSubordinateStatement(entity *Entity, sub string) ([]byte, error) {
+	info, err := filteringSubordinateStorage{entity.Storage.SubordinateStorage()}.Subordinate(sub)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || info.Status != storage.StatusActive {
+		return nil, nil
+	}
+	return signSubordinateStatement(entity, info, 0)
+}
+
+// signSubordinateStatement signs a subordinate statement from info, issued age before now.
+func signSubordinateStatement(entity *Entity, info *storage.SubordinateInfo, age time.Duration) ([]byte, error) {
+	fedentity := entity.FedEntity
+	payload := fedentity.CreateSubordinateStatement(info)
+	if info.Constraints != nil {
+		payload.Constraints = info.Constraints
+	}
+	if info.MetadataPolicy != nil {
+		payload.MetadataPolicy = info.MetadataPolicy
+		payload.MetadataPolicyCrit = info.MetadataPolicyCrit
+	}
+	payload.IssuedAt.Time = payload.IssuedAt.Add(-age)
+	payload.ExpiresAt.Time = payload.ExpiresAt.Add(-age)
+	jwt, err := fedentity.SignEntityStatement(payload)
+	if err != nil {
+		return nil, err
+	}
+	return entity.resign(jwt)
+}
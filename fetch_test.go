@@ -0,0 +1,155 @@
+package minifed
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFetchConstraints(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - edge: TA -> IM
+    max_path_length: 1
+    allowed_entity_types: [openid_relying_party]
+    naming_constraints:
+      permitted: [.example.com]
+  - IM -> L
+`)
+	resp := get(t, handler, "https://ta.example.com/fetch?sub=https://im.example.com")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("fetch: status %d: %s", resp.Code, resp.Body)
+	}
+	want := `{"allowed_entity_types":["openid_relying_party"],"max_path_length":1,"naming_constraints":{"permitted":[".example.com"]}}`
+	if got := claimJSON(t, decodeJWT(t, resp.Body.Bytes())["constraints"]); string(got) != want {
+		t.Errorf("constraints are %s, want %s", got, want)
+	}
+
+	// The edge below has no constraints, so neither has its statement.
+	resp = get(t, handler, "https://im.example.com/fetch?sub=https://l.example.com")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("fetch: status %d: %s", resp.Code, resp.Body)
+	}
+	if constraints, ok := decodeJWT(t, resp.Body.Bytes())["constraints"]; ok {
+		encoded, _ := json.Marshal(constraints)
+		t.Errorf("statement about L has constraints %s", encoded)
+	}
+}
+
+func TestFetchPreviousVersion(t *testing.T) {
+	entities, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+  B:
+    kind: leaf
+    identifier: https://b.example.com
+  N:
+    kind: leaf
+    identifier: https://n.example.com
+edges:
+  - edge: TA -> L
+    previous:
+      max_path_length: 2
+    previous_age: 24h
+  - edge: TA -> B
+    status: blocked
+    previous: {}
+  - TA -> N
+`)
+	current := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com")
+	resp := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com&version=previous")
+	if current.Code != http.StatusOK || resp.Code != http.StatusOK {
+		t.Fatalf("fetch: status %d, and %d for the previous version: %s", current.Code, resp.Code, resp.Body)
+	}
+	now, previous := decodeJWT(t, current.Body.Bytes()), decodeJWT(t, resp.Body.Bytes())
+	if constraints, ok := now["constraints"]; ok {
+		t.Errorf("current statement about L has constraints %s", claimJSON(t, constraints))
+	}
+	if got, want := string(claimJSON(t, previous["constraints"])), `{"max_path_length":2}`; got != want {
+		t.Errorf("previous statement about L has the constraints %s, want %s", got, want)
+	}
+	// It's backdated by previous_age, and carries the same keys.
+	for _, claim := range []string{"iat", "exp"} {
+		if age := now[claim].(float64) - previous[claim].(float64); math.Abs(age-86400) > 60 {
+			t.Errorf("previous statement about L has %s %v seconds before the current one, want 86400", claim, age)
+		}
+	}
+	if got, want := claimJSON(t, previous["jwks"]), claimJSON(t, now["jwks"]); string(got) != string(want) {
+		t.Errorf("previous statement about L has the JWKS %s, want %s", got, want)
+	}
+	// It's served as is until it expires, like the current one.
+	if again := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com&version=previous"); again.Body.String() != resp.Body.String() {
+		t.Error("previous statement about L was signed anew")
+	}
+
+	// The previous version is served whatever the status of the subordinate, and not at all if
+	// the edge has none.
+	for target, want := range map[string]int{
+		"https://ta.example.com/fetch?sub=https://b.example.com":                  http.StatusNotFound,
+		"https://ta.example.com/fetch?sub=https://b.example.com&version=previous": http.StatusOK,
+		"https://ta.example.com/fetch?sub=https://n.example.com&version=current":  http.StatusOK,
+		"https://ta.example.com/fetch?sub=https://n.example.com&version=previous": http.StatusNotFound,
+		"https://ta.example.com/fetch?sub=https://l.example.com&version=older":    http.StatusBadRequest,
+	} {
+		if resp := get(t, handler, target); resp.Code != want {
+			t.Errorf("%s: status %d, want %d", target, resp.Code, want)
+		}
+	}
+
+	// The admin API takes the version too.
+	decoded := adminRequest(t, entities, http.MethodGet, "/fetch-decoded?entity=TA&sub=https://l.example.com&version=previous")
+	if decoded.Code != http.StatusOK || !strings.Contains(decoded.Body.String(), `"max_path_length": 2`) {
+		t.Errorf("fetch-decoded of the previous version: status %d: %s", decoded.Code, decoded.Body)
+	}
+	missing := adminRequest(t, entities, http.MethodGet, "/fetch-decoded?entity=TA&sub=https://n.example.com&version=previous")
+	if want := "TA has no previous statement about https://n.example.com"; missing.Code != http.StatusNotFound || !strings.Contains(missing.Body.String(), want) {
+		t.Errorf("fetch-decoded of a missing previous version: status %d: %s, want 404 saying %q", missing.Code, missing.Body, want)
+	}
+}
+
+func TestFetchPreviousVersionErrors(t *testing.T) {
+	for options, want := range map[string]string{
+		"previous: {status: blocked}":     "previous can only set the options of an edge that go into its statement",
+		"previous_age: 1h":                "previous_age must be given with previous",
+		"previous: {max_path_length: -1}": "previous: max_path_length must be positive",
+	} {
+		cfg, err := ParseConfig(writeTestConfig(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - edge: TA -> L
+    `+options+`
+`))
+		if err == nil {
+			var entities map[string]*Entity
+			if entities, _, err = BuildFederation(cfg); err == nil {
+				closeEntities(entities)
+			}
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want it to say %q", options, err, want)
+		}
+	}
+}
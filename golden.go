@@ -0,0 +1,153 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// goldenVolatileClaims are left out of the JWTs in golden files, since they change every run.
+var goldenVolatileClaims = []string{"iat", "exp"}
+
+// runGolden implements the golden subcommand, which builds the federation of a configuration and
+// writes the public JWKS and entity configuration of each entity to a directory, serialized the
+// same way every time, so that they can be committed as golden files and diffed. The entity
+// configuration is written decoded, without its signature and timestamps, and so are the JWTs
+// within it, such as trust marks. The output only stays the same across runs if the keys do,
+// see KeyConfig.File and Config.KeyCache.
+func runGolden(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: minifed golden config.yaml directory")
+	}
+	filename, dir := args[0], args[1]
+	config, err := ParseConfig(filename)
+	if err != nil {
+		return err
+	}
+	entities, _, err := BuildFederation(config)
+	if err != nil {
+		return err
+	}
+	defer closeEntities(entities)
+
+	files := map[string][]byte{}
+	for _, name := range slices.Sorted(maps.Keys(entities)) {
+		entity := entities[name]
+		if entity.External {
+			continue
+		}
+		base := url.PathEscape(name) + ".json"
+		jwks, err := json.Marshal(entity.FedEntity.EntityConfigurationPayload().JWKS)
+		if err == nil {
+			files[filepath.Join(archiveJWKSDir, base)], err = canonicalJSON(jwks)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		jwt, err := entity.signedEntityConfiguration()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		decoded, ok := decodeGoldenJWT(string(jwt))
+		if !ok {
+			return fmt.Errorf("%s: entity configuration is not a JWT", name)
+		}
+		if files[filepath.Join(archiveEntityConfigurationDir, base)], err = marshalCanonical(decoded); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return fmt.Errorf("golden: %w", err)
+		}
+		if err := os.WriteFile(target, files[name], 0o644); err != nil {
+			return fmt.Errorf("golden: %w", err)
+		}
+	}
+	fmt.Printf("wrote %d golden files to %s\n", len(files), dir)
+	return nil
+}
+
+// canonicalJSON reserializes the JSON document content canonically: indented, with the keys of
+// objects sorted and numbers as written, and with any JWTs in it decoded, see decodeGoldenJWT.
+func canonicalJSON(content []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return marshalCanonical(decodeGoldenJWTs(document))
+}
+
+// marshalCanonical serializes document, which must be made of the values that encoding/json
+// decodes into, which has it sort the keys of objects.
+func marshalCanonical(document any) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decodeGoldenJWTs replaces the JWTs anywhere in document with their decoded form.
+func decodeGoldenJWTs(document any) any {
+	switch value := document.(type) {
+	case map[string]any:
+		for key, member := range value {
+			value[key] = decodeGoldenJWTs(member)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = decodeGoldenJWTs(item)
+		}
+	case string:
+		if decoded, ok := decodeGoldenJWT(value); ok {
+			return decoded
+		}
+	}
+	return document
+}
+
+// decodeGoldenJWT decodes the header and payload of jwt, leaving out the signature, which can
+// differ every time it's signed, and goldenVolatileClaims. JWTs within it are decoded too. It
+// reports false if jwt isn't a JWT with a JSON header and payload.
+func decodeGoldenJWT(jwt string) (map[string]any, bool) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var decoded [2]map[string]any
+	for i, part := range parts[:2] {
+		content, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, false
+		}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded[i]); err != nil || decoded[i] == nil {
+			return nil, false
+		}
+	}
+	header, payload := decoded[0], decoded[1]
+	if _, ok := header["alg"]; !ok {
+		return nil, false
+	}
+	for _, claim := range goldenVolatileClaims {
+		delete(payload, claim)
+	}
+	return map[string]any{"header": header, "payload": decodeGoldenJWTs(payload)}, true
+}
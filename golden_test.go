@@ -0,0 +1,95 @@
+package minifed
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoldenIsStable(t *testing.T) {
+	config := writeTestConfig(t, `
+key_cache: keys
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    key:
+      file: ta.pem
+      alg: ES256
+  TMI:
+    kind: intermediate
+    identifier: https://tmi.example.com
+    trust_marks:
+      - id: https://tmi.example.com/marks/certified
+        lifetime: 3600
+        subjects: [L]
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    openid_provider: true
+    key:
+      file: l.pem
+      alg: EdDSA
+edges:
+  - TA -> TMI
+  - TMI -> L
+`)
+	for name, alg := range map[string]string{"ta.pem": "ES256", "l.pem": "EdDSA"} {
+		key, err := generateSigningKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := encodeSigningKey(key)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(filepath.Dir(config), name), content, 0o600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// TMI's key is generated by the first run, and reused from the key cache by the second.
+	first, second := filepath.Join(t.TempDir(), "first"), filepath.Join(t.TempDir(), "second")
+	var err error
+	captureStdout(t, func() {
+		if err = runGolden([]string{config, first}); err == nil {
+			err = runGolden([]string{config, second})
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := 0
+	err = filepath.WalkDir(first, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		files++
+		name, _ := filepath.Rel(first, path)
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(second, name))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs between runs:\n%s\nthen:\n%s", name, want, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A JWKS and an entity configuration for each of the three entities.
+	if files != 6 {
+		t.Errorf("wrote %d golden files, want 6", files)
+	}
+	if entries, err := os.ReadDir(filepath.Join(second, archiveEntityConfigurationDir)); err != nil || len(entries) != 3 {
+		t.Errorf("second run wrote %d entity configurations (%v), want 3", len(entries), err)
+	}
+}
@@ -0,0 +1,125 @@
+package minifed
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// starterConfig is the configuration written by the init subcommand.
+var starterConfig = template.Must(template.New("config").
+	Funcs(template.FuncMap{"lower": strings.ToLower}).
+	Parse(`# A minifed federation of a trust anchor, an intermediate below it, and leaves below the
+# intermediate. Run it with: minifed {{.Filename}}
+#
+# Each entity is served at the host of its identifier, so requests to it must carry that host in
+# their Host header, e.g.
+#
+#   curl http://localhost:8080/.well-known/openid-federation -H "Host: ta.{{.Domain}}"
+#
+# Pass -path-routing http://localhost:8080 to serve each entity under a path prefix instead.
+# See the documentation of Config for everything else that can be configured.
+
+entities:
+  # The trust anchor is the root of the federation, which clients are configured to trust.
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.{{.Domain}}
+  # Intermediates issue subordinate statements about the entities below them, like trust anchors.
+  IM:
+    kind: intermediate
+    identifier: https://im.{{.Domain}}
+  # Leaves have no subordinates, e.g. OpenID providers and relying parties. Set
+  # openid_provider: true to advertise OpenID provider metadata.
+{{- range .Leaves}}
+  {{.}}:
+    kind: leaf
+    identifier: https://{{lower .}}.{{$.Domain}}
+{{- end}}
+
+# Edges are the trust relationships, written "superior -> subordinate". A chain such as
+# "TA -> IM -> L" stands for each edge along it. Edges can also be written as mappings, e.g. to
+# constrain the subordinate:
+#
+#   - edge: TA -> IM
+#     max_path_length: 1
+edges:
+  - TA -> IM
+{{- range .Leaves}}
+  - IM -> {{.}}
+{{- end}}
+`))
+
+// runInit implements the init subcommand, which writes a starter configuration for a small
+// federation to get started with.
+func runInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: minifed init [flags]\n\n")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "config.yaml", "file to write the configuration to, or - for standard output")
+	domain := flags.String("domain", "example.com", "domain that the identifiers of the entities are subdomains of")
+	leaves := flags.Int("leaves", 1, "number of leaves below the intermediate")
+	force := flags.Bool("force", false, "overwrite the file if it exists")
+	_ = flags.Parse(args)
+
+	if *leaves < 1 {
+		return errors.New("init: -leaves must be positive")
+	}
+	if *domain == "" || strings.ContainsAny(*domain, "/: ") {
+		return fmt.Errorf("init: invalid domain %q", *domain)
+	}
+	data := struct {
+		Filename, Domain string
+		Leaves           []string
+	}{Filename: *output, Domain: *domain}
+	if *output == "-" {
+		data.Filename = "config.yaml"
+	}
+	for i := range *leaves {
+		name := "L"
+		if *leaves > 1 {
+			name = fmt.Sprintf("L%d", i+1)
+		}
+		data.Leaves = append(data.Leaves, name)
+	}
+	var config strings.Builder
+	if err := starterConfig.Execute(&config, data); err != nil {
+		return err
+	}
+
+	// Make sure the configuration is one that minifed accepts, before handing it out.
+	var parsed Config
+	if err := decodeStrict([]byte(config.String()), &parsed, true); err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+	if err := parsed.validate(); err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+
+	if *output == "-" {
+		_, err := fmt.Print(config.String())
+		return err
+	}
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(*output, mode, 0o644)
+	if err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+	if _, err := file.WriteString(config.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("init: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+	fmt.Printf("wrote %s, run it with: minifed %s\n", *output, *output)
+	return nil
+}
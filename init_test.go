@@ -0,0 +1,63 @@
+package minifed
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitStdout(t *testing.T) {
+	var err error
+	output := captureStdout(t, func() { err = runInit([]string{"-leaves", "3", "-domain", "x.test", "-o", "-"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The configuration written builds, and serves the entities it describes.
+	entities, handler := buildTestFederation(t, output)
+	if len(entities) != 5 {
+		t.Errorf("configuration written has %d entities, want 5", len(entities))
+	}
+	for _, name := range []string{"l1", "l2", "l3"} {
+		_, claims := entityConfiguration(t, handler, "https://"+name+".x.test")
+		if hints := string(claimJSON(t, claims["authority_hints"])); hints != `["https://im.x.test"]` {
+			t.Errorf("authority_hints of %s are %s", name, hints)
+		}
+	}
+}
+
+func TestInitFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	var err error
+	output := captureStdout(t, func() { err = runInit([]string{"-o", filename}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "wrote "+filename) {
+		t.Errorf("output is %q", output)
+	}
+	written, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing file is only overwritten with -force.
+	if err := os.WriteFile(filename, []byte("mine\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runInit([]string{"-o", filename}); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("writing over an existing file: %v, want it to exist", err)
+	}
+	if content, _ := os.ReadFile(filename); string(content) != "mine\n" {
+		t.Errorf("existing file was overwritten with %s", content)
+	}
+	captureStdout(t, func() { err = runInit([]string{"-o", filename, "-force"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filename); string(content) != string(written) {
+		t.Errorf("existing file wasn't overwritten with -force: %s", content)
+	}
+}
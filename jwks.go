@@ -0,0 +1,83 @@
+package minifed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+const (
+	// ContentTypeJWKS is the content type of a JWK set, per RFC 7517.
+	ContentTypeJWKS = "application/jwk-set+json"
+	// ContentTypeSignedJWKS is the content type of a signed JWK set, per OpenID Federation 1.0.
+	ContentTypeSignedJWKS = "application/jwk-set+jwt"
+	// JWTTypeSignedJWKS is the typ header of a signed JWK set.
+	JWTTypeSignedJWKS = "jwk-set+jwt"
+)
+
+// setJWKSURIs references the JWKS endpoints of e in its federation_entity metadata, and its
+// openid_provider metadata if it has any.
+func (e *Entity) setJWKSURIs(metadata *oidcfed.Metadata) error {
+	var jwksURI, signedJWKSURI string
+	var err error
+	if e.JWKSURI {
+		if jwksURI, err = url.JoinPath(e.Identifier.String(), e.Endpoints.JWKS); err != nil {
+			return err
+		}
+	}
+	if e.SignedJWKSURI {
+		if signedJWKSURI, err = url.JoinPath(e.Identifier.String(), e.Endpoints.SignedJWKS); err != nil {
+			return err
+		}
+	}
+
+	metadata.FederationEntity.JWKSURI = jwksURI
+	metadata.FederationEntity.SignedJWKSURI = signedJWKSURI
+	if metadata.OpenIDProvider != nil {
+		metadata.OpenIDProvider.JWKSURI = jwksURI
+		metadata.OpenIDProvider.SignedJWKSURI = signedJWKSURI
+	}
+	return nil
+}
+
+// jwksHandler serves the public keys of entity as a JWK set.
+func jwksHandler(entity *Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentTypeJWKS)
+		writeJSON(w, http.StatusOK, entity.FedEntity.EntityConfigurationPayload().JWKS)
+	}
+}
+
+// signedJWKSHandler serves the public keys of entity as a JWK set signed by entity.
+func signedJWKSHandler(entity *Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The payload is the JWK set itself, with the claims identifying the entity alongside the
+		// keys.
+		encoded, err := json.Marshal(entity.FedEntity.EntityConfigurationPayload().JWKS)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+			return
+		}
+		payload := map[string]any{}
+		if err := json.Unmarshal(encoded, &payload); err != nil {
+			writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+			return
+		}
+		payload["iss"] = entity.Identifier.String()
+		payload["sub"] = entity.Identifier.String()
+		payload["iat"] = time.Now().Unix()
+		jwt, err := entity.FedEntity.GeneralJWTSigner.JWT(payload, JWTTypeSignedJWKS)
+		if err == nil {
+			jwt, err = entity.resign(jwt)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", ContentTypeSignedJWKS)
+		_, _ = w.Write(jwt)
+	}
+}
@@ -0,0 +1,63 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestJWKSURIMatchesSigningKey(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    jwks_uri: true
+    signed_jwks_uri: true
+edges:
+  - TA -> L
+`)
+	configuration, claims := entityConfiguration(t, handler, "https://l.example.com")
+	metadata, _ := claims["metadata"].(map[string]any)
+	federationEntity, _ := metadata["federation_entity"].(map[string]any)
+	jwksURI, _ := federationEntity["jwks_uri"].(string)
+	signedJWKSURI, _ := federationEntity["signed_jwks_uri"].(string)
+	if jwksURI == "" || signedJWKSURI == "" {
+		t.Fatalf("federation_entity metadata lacks jwks_uri or signed_jwks_uri: %v", federationEntity)
+	}
+
+	resp := get(t, handler, jwksURI)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != ContentTypeJWKS {
+		t.Fatalf("jwks_uri: status %d, content type %q", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	jwks := resp.Body.Bytes()
+	if _, err := verifyJWT(configuration, jwks); err != nil {
+		t.Errorf("entity configuration doesn't verify with the keys of jwks_uri: %s", err)
+	}
+	var served, configured any
+	_ = json.Unmarshal(jwks, &served)
+	_ = json.Unmarshal(claimJSON(t, claims["jwks"]), &configured)
+	if !bytes.Equal(claimJSON(t, served), claimJSON(t, configured)) {
+		t.Errorf("jwks_uri serves %s, but the entity configuration has %s", claimJSON(t, served), claimJSON(t, configured))
+	}
+
+	resp = get(t, handler, signedJWKSURI)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != ContentTypeSignedJWKS {
+		t.Fatalf("signed_jwks_uri: status %d, content type %q", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	payload, err := verifyJWT(resp.Body.Bytes(), jwks)
+	if err != nil {
+		t.Fatalf("signed JWKS doesn't verify with the keys of jwks_uri: %s", err)
+	}
+	var signed struct {
+		Keys []any `json:"keys"`
+	}
+	_ = json.Unmarshal(payload, &signed)
+	if keys := configured.(map[string]any)["keys"].([]any); len(signed.Keys) != len(keys) {
+		t.Errorf("signed JWKS has %d keys, want %d", len(signed.Keys), len(keys))
+	}
+}
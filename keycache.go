@@ -0,0 +1,133 @@
+package minifed
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// keyCacheManifest is the file of a key cache that records which key config each cached key was
+// generated for.
+const keyCacheManifest = "manifest.json"
+
+// keyCache is a directory of generated keys that are reused by later runs, for as long as the
+// key configs they were generated for stay the same. Unlike the file of a key config, it's meant
+// to be thrown away at any time, and only spares generating keys anew on every run.
+type keyCache struct {
+	dir string
+
+	mu sync.Mutex
+	// keys are the cached keys of each entity, in the order of Config.entityKeys.
+	keys    map[string][]cachedKey
+	changed bool
+}
+
+// cachedKey is an entry of the manifest of a key cache.
+type cachedKey struct {
+	// Hash is that of the key config the key was generated for, see hashKeyConfig.
+	Hash string `json:"hash"`
+	// File is the PEM file of the key, relative to the cache.
+	File string `json:"file"`
+}
+
+// openKeyCache opens the key cache in dir, creating it if it doesn't exist.
+func openKeyCache(dir string) (*keyCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("key cache: %w", err)
+	}
+	cache := &keyCache{dir: dir, keys: map[string][]cachedKey{}}
+	content, err := os.ReadFile(filepath.Join(dir, keyCacheManifest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key cache: %w", err)
+	}
+	if err := json.Unmarshal(content, &cache.keys); err != nil {
+		// The cache can always be rebuilt, so start it over rather than fail.
+		slog.Warn("discarding key cache, its manifest is unreadable", "dir", dir, "err", err)
+		cache.keys = map[string][]cachedKey{}
+	}
+	return cache, nil
+}
+
+// hashKeyConfig hashes everything about config, so that any change to it voids the cached key.
+func hashKeyConfig(config KeyConfig) string {
+	encoded, _ := json.Marshal(config)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// signingKey returns the cached key at index among those of entity, if it was generated for
+// config, or else generates one and caches it in place of the old one.
+func (c *keyCache) signingKey(entity string, index int, config KeyConfig) (crypto.Signer, error) {
+	hash := hashKeyConfig(config)
+	c.mu.Lock()
+	var cached cachedKey
+	if index < len(c.keys[entity]) {
+		cached = c.keys[entity][index]
+	}
+	c.mu.Unlock()
+	if cached.Hash == hash {
+		key, err := loadSigningKey(filepath.Join(c.dir, cached.File), config.Alg)
+		if err == nil {
+			slog.Debug("reusing cached signing key", "entity", entity, "alg", config.Alg, "file", cached.File)
+			return key, nil
+		}
+		slog.Warn("regenerating cached signing key", "entity", entity, "err", err)
+	}
+
+	key, err := generateSigningKey(config.Alg)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := encodeSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	cached = cachedKey{Hash: hash, File: fmt.Sprintf("%s-%d.pem", url.PathEscape(entity), index)}
+	if err := os.WriteFile(filepath.Join(c.dir, cached.File), pem, 0o600); err != nil {
+		return nil, fmt.Errorf("key cache: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.keys[entity]) <= index {
+		c.keys[entity] = append(c.keys[entity], cachedKey{})
+	}
+	c.keys[entity][index] = cached
+	c.changed = true
+	return key, nil
+}
+
+// save writes the manifest of the cache, if any key was generated since it was opened.
+func (c *keyCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	content, err := json.MarshalIndent(c.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write the manifest in one go, so that a run that's cut short can't leave half of it behind.
+	temp := filepath.Join(c.dir, keyCacheManifest+".tmp")
+	if err := os.WriteFile(temp, append(content, '\n'), 0o600); err != nil {
+		return fmt.Errorf("key cache: %w", err)
+	}
+	if err := os.Rename(temp, filepath.Join(c.dir, keyCacheManifest)); err != nil {
+		return fmt.Errorf("key cache: %w", err)
+	}
+	c.changed = false
+	return nil
+}
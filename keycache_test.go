@@ -0,0 +1,53 @@
+package minifed
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyCache(t *testing.T) {
+	dir := t.TempDir()
+	jwks := func(alg string) map[string][]byte {
+		t.Helper()
+		_, handler := buildTestFederation(t, `
+key_cache: `+dir+`
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    key:
+      alg: `+alg+`
+edges:
+  - TA -> L
+`)
+		keys := map[string][]byte{}
+		for _, id := range []string{"https://ta.example.com", "https://l.example.com"} {
+			_, claims := entityConfiguration(t, handler, id)
+			keys[id] = claimJSON(t, claims["jwks"])
+		}
+		return keys
+	}
+
+	first, second := jwks("ES256"), jwks("ES256")
+	for id, keys := range first {
+		if !bytes.Equal(keys, second[id]) {
+			t.Errorf("%s has the JWKS %s, then %s with the same key config", id, keys, second[id])
+		}
+	}
+
+	// Changing the key config of L rotates its key, and only its key.
+	rotated := jwks("ES384")
+	if bytes.Equal(rotated["https://l.example.com"], first["https://l.example.com"]) {
+		t.Errorf("L kept its key when its alg changed: %s", rotated["https://l.example.com"])
+	}
+	if !bytes.Equal(rotated["https://ta.example.com"], first["https://ta.example.com"]) {
+		t.Errorf("TA has the JWKS %s, then %s, though its key config didn't change", first["https://ta.example.com"], rotated["https://ta.example.com"])
+	}
+	// The rotated key is cached in turn.
+	if again := jwks("ES384"); !bytes.Equal(again["https://l.example.com"], rotated["https://l.example.com"]) {
+		t.Errorf("L has the JWKS %s, then %s with the same key config", rotated["https://l.example.com"], again["https://l.example.com"])
+	}
+}
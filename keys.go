@@ -0,0 +1,156 @@
+package minifed
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	fedjwk "github.com/zachmann/go-oidfed/pkg/jwk"
+)
+
+// DefaultKeyAlg is the algorithm entities sign with, unless configured otherwise.
+const DefaultKeyAlg = string(jwa.ES512)
+
+// keyAlgorithms generate a signing key for each supported JWS algorithm. The RSA keys are 2048
+// bits.
+var keyAlgorithms = map[string]func() (crypto.Signer, error){
+	"ES256": ecdsaKey(ecdsaCurves["ES256"]),
+	"ES384": ecdsaKey(ecdsaCurves["ES384"]),
+	"ES512": ecdsaKey(ecdsaCurves["ES512"]),
+	"RS256": rsaKey,
+	"RS384": rsaKey,
+	"RS512": rsaKey,
+	"PS256": rsaKey,
+	"PS384": rsaKey,
+	"PS512": rsaKey,
+	"EdDSA": func() (crypto.Signer, error) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	},
+}
+
+// ecdsaCurves are the curves of the keys of each ECDSA algorithm.
+var ecdsaCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+func ecdsaKey(curve elliptic.Curve) func() (crypto.Signer, error) {
+	return func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+}
+
+func rsaKey() (crypto.Signer, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// checkKeyAlg checks that signing keys can be generated for alg.
+func checkKeyAlg(alg string) error {
+	if _, ok := keyAlgorithms[alg]; !ok {
+		return fmt.Errorf("unsupported alg %q, must be one of %v", alg, slices.Sorted(maps.Keys(keyAlgorithms)))
+	}
+	return nil
+}
+
+// generateSigningKey generates a signing key for alg.
+func generateSigningKey(alg string) (crypto.Signer, error) {
+	generate, ok := keyAlgorithms[alg]
+	if !ok {
+		return nil, checkKeyAlg(alg)
+	}
+	return generate()
+}
+
+// loadSigningKey reads the PKCS #8 private key in the PEM file filename, and checks that it can
+// sign with alg.
+func loadSigningKey(filename, alg string) (crypto.Signer, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PKCS #8 private key found", filename)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := checkKeyAlg(alg); err != nil {
+		return nil, err
+	}
+	var ok bool
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		ok = ecdsaCurves[alg] == key.Curve
+	case *rsa.PrivateKey:
+		ok = strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case ed25519.PrivateKey:
+		ok = alg == string(jwa.EdDSA)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: key of type %T can't sign with %s", filename, key, alg)
+	}
+	return key.(crypto.Signer), nil
+}
+
+// encodeSigningKey encodes key as a PEM block of its PKCS #8 form, as read by loadSigningKey.
+func encodeSigningKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// headerKID returns the key id for the headers of the JWTs e signs, or "" to leave it as is.
+func (e *Entity) headerKID() string {
+	if e.Key.HeaderKID != "" {
+		return e.Key.HeaderKID
+	}
+	return e.Key.KID
+}
+
+// publishedKey is a key of an entity that it publishes, but doesn't sign with.
+type publishedKey struct {
+	config KeyConfig
+	key    crypto.Signer
+}
+
+// setupJWKS sets the configured key id on the signing key in the JWKS of e, and adds the other
+// keys of e to it. The JWKS is shared by everything that publishes it, such as the entity
+// configuration and the subordinate statements issued about e.
+func (e *Entity) setupJWKS() error {
+	jwks := e.FedEntity.EntityConfigurationPayload().JWKS
+	if e.Key.KID != "" {
+		// The JWKS only holds the signing key, so far.
+		key, _ := jwks.Get(0)
+		if err := key.Set(jwk.KeyIDKey, e.Key.KID); err != nil {
+			return fmt.Errorf("%s: %w", e.Name, err)
+		}
+	}
+	for _, other := range e.OtherKeys {
+		key, _ := fedjwk.KeyToJWKS(other.key.Public(), jwa.SignatureAlgorithm(other.config.Alg)).Get(0)
+		if other.config.KID != "" {
+			if err := key.Set(jwk.KeyIDKey, other.config.KID); err != nil {
+				return fmt.Errorf("%s: %w", e.Name, err)
+			}
+		}
+		jwks.Add(key)
+	}
+	return nil
+}
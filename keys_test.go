@@ -0,0 +1,223 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+// headerKID returns the kid in the protected header of jwt.
+func headerKID(t *testing.T, jwt []byte) string {
+	t.Helper()
+	message, err := jws.Parse(jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.Signatures()[0].ProtectedHeaders().KeyID()
+}
+
+func TestKeyID(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    key:
+      kid: ta-key
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    key:
+      kid: l-key
+  H:
+    kind: leaf
+    identifier: https://h.example.com
+    key:
+      kid: h-key
+      header_kid: elsewhere
+edges:
+  - TA -> L
+  - TA -> H
+`)
+	configuration, claims := entityConfiguration(t, handler, "https://l.example.com")
+	keys, _ := claims["jwks"].(map[string]any)["keys"].([]any)
+	if len(keys) != 1 || keys[0].(map[string]any)["kid"] != "l-key" {
+		t.Fatalf("JWKS of L is %s, want the single key l-key", claimJSON(t, claims["jwks"]))
+	}
+	if kid := headerKID(t, configuration); kid != "l-key" {
+		t.Errorf("entity configuration of L has the header kid %q, want l-key", kid)
+	}
+	if _, err := verifyJWT(configuration, claimJSON(t, claims["jwks"])); err != nil {
+		t.Errorf("entity configuration of L doesn't verify with its JWKS: %s", err)
+	}
+
+	// The superior signs the statements it issues with its own key id.
+	_, anchor := entityConfiguration(t, handler, "https://ta.example.com")
+	resp := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com")
+	if kid := headerKID(t, resp.Body.Bytes()); kid != "ta-key" {
+		t.Errorf("statement about L has the header kid %q, want ta-key", kid)
+	}
+	if _, err := verifyJWT(resp.Body.Bytes(), claimJSON(t, anchor["jwks"])); err != nil {
+		t.Errorf("statement about L doesn't verify with the JWKS of TA: %s", err)
+	}
+
+	// With header_kid, the header names a key that isn't in the JWKS.
+	configuration, claims = entityConfiguration(t, handler, "https://h.example.com")
+	if kid := headerKID(t, configuration); kid != "elsewhere" {
+		t.Errorf("entity configuration of H has the header kid %q, want elsewhere", kid)
+	}
+	if _, err := verifyJWT(configuration, claimJSON(t, claims["jwks"])); err == nil {
+		t.Error("entity configuration of H verifies, though its header kid isn't in its JWKS")
+	}
+}
+
+func TestKeyErrorsOfEveryEntity(t *testing.T) {
+	cfg, err := ParseConfig(writeTestConfig(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  A:
+    kind: leaf
+    identifier: https://a.example.com
+    key:
+      file: missing-a.pem
+  B:
+    kind: leaf
+    identifier: https://b.example.com
+    key:
+      file: missing-b.pem
+edges:
+  - TA -> A
+  - TA -> B
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, _, err := BuildFederation(cfg)
+	if err == nil {
+		closeEntities(entities)
+		t.Fatal("built a federation whose key files are missing")
+	}
+	for _, want := range []string{"A: ", "missing-a.pem", "B: ", "missing-b.pem"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func TestSeveralKeys(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    keys:
+      - kid: ta-old
+        alg: RS256
+      - kid: ta-new
+        signing: true
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    keys:
+      - kid: l-old
+        alg: ES256
+      - kid: l-new
+        alg: EdDSA
+        signing: true
+      - alg: ES384
+edges:
+  - TA -> L
+`)
+	configuration, claims := entityConfiguration(t, handler, "https://l.example.com")
+	keys, _ := claims["jwks"].(map[string]any)["keys"].([]any)
+	algs := map[string]bool{}
+	for _, key := range keys {
+		algs[key.(map[string]any)["alg"].(string)] = true
+	}
+	if len(keys) != 3 || !algs["ES256"] || !algs["EdDSA"] || !algs["ES384"] {
+		t.Errorf("JWKS of L is %s, want its three keys", claimJSON(t, claims["jwks"]))
+	}
+	if kid := headerKID(t, configuration); kid != "l-new" {
+		t.Errorf("entity configuration of L is signed with %q, want l-new", kid)
+	}
+	if _, err := verifyJWT(configuration, claimJSON(t, claims["jwks"])); err != nil {
+		t.Errorf("entity configuration of L doesn't verify with its JWKS: %s", err)
+	}
+
+	configuration, anchor := entityConfiguration(t, handler, "https://ta.example.com")
+	if keys, _ := anchor["jwks"].(map[string]any)["keys"].([]any); len(keys) != 2 {
+		t.Errorf("JWKS of TA is %s, want its two keys", claimJSON(t, anchor["jwks"]))
+	}
+	resp := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com")
+	for name, jwt := range map[string][]byte{"entity configuration of TA": configuration, "statement about L": resp.Body.Bytes()} {
+		if kid := headerKID(t, jwt); kid != "ta-new" {
+			t.Errorf("%s is signed with %q, want ta-new", name, kid)
+		}
+		if _, err := verifyJWT(jwt, claimJSON(t, anchor["jwks"])); err != nil {
+			t.Errorf("%s doesn't verify with the JWKS of TA: %s", name, err)
+		}
+	}
+	// The statement about L lists all of its keys, as its entity configuration does.
+	if statement := decodeJWT(t, resp.Body.Bytes()); !bytes.Equal(claimJSON(t, statement["jwks"]), claimJSON(t, claims["jwks"])) {
+		t.Errorf("statement about L has the JWKS %s, want %s", claimJSON(t, statement["jwks"]), claimJSON(t, claims["jwks"]))
+	}
+}
+
+func TestFaultAlg(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+    key:
+      kid: l-key
+      alg: ES512
+      fault_alg: ES256
+edges:
+  - TA -> L
+`)
+	configuration, claims := entityConfiguration(t, handler, "https://l.example.com")
+	message, err := jws.Parse(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := message.Signatures()[0].ProtectedHeaders()
+	if headers.Algorithm() != jwa.ES256 || headers.KeyID() != "l-key" {
+		t.Errorf("entity configuration of L has the header alg %s and kid %q, want ES256 and l-key", headers.Algorithm(), headers.KeyID())
+	}
+	jwks := claimJSON(t, claims["jwks"])
+	if _, err := verifyJWT(configuration, jwks); err == nil {
+		t.Error("entity configuration of L verifies with its JWKS")
+	}
+	// Nor does it verify with the key of its JWKS, taking the alg of its header at face value.
+	set, err := jwk.Parse(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := set.LookupKeyID("l-key")
+	if _, err := jws.Verify(configuration, jwa.ES256, key); err == nil {
+		t.Error("entity configuration of L verifies with its key, for the alg of its header")
+	}
+
+	recorder := httptest.NewRecorder()
+	handleVerify(recorder, httptest.NewRequest(http.MethodGet, "/verify?sub=https://l.example.com&anchor=https://ta.example.com", nil))
+	var verified verifyResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &verified); err != nil {
+		t.Fatal(err)
+	}
+	if verified.Valid {
+		t.Errorf("verify: a chain from L is valid: %s", recorder.Body)
+	}
+}
@@ -0,0 +1,133 @@
+package minifed
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeStrict decodes the YAML document content into v, rejecting keys that no field of v is
+// decoded from, so that a misspelled setting is an error rather than silently having no effect.
+// Set lines to report the line of such keys along with their path in the document, which is only
+// meaningful if content is what the user wrote.
+func decodeStrict(content []byte, v any, lines bool) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return err
+	}
+	if document.Kind == 0 {
+		// The document is empty.
+		return nil
+	}
+	// yaml.Decoder.KnownFields would do, if it carried over to the unmarshalers of the fields,
+	// such as that of Edge, so the document is checked on its own instead.
+	if err := checkKnownFields(&document, reflect.TypeOf(v), "", lines); err != nil {
+		return err
+	}
+	return document.Decode(v)
+}
+
+// checkKnownFields checks that each mapping in node that's decoded into a struct of type t only
+// has keys naming fields of the struct. path is that of node in the document, for errors.
+func checkKnownFields(node *yaml.Node, t reflect.Type, path string, lines bool) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, content := range node.Content {
+			if err := checkKnownFields(content, t, path, lines); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.AliasNode:
+		return checkKnownFields(node.Alias, t, path, lines)
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for i, item := range node.Content {
+			if err := checkKnownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), lines); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.MappingNode:
+	default:
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if err := checkKnownFields(value, t.Elem(), joinFieldPath(path, key.Value), lines); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" && key.Tag == "!!merge" {
+				// The keys of merged mappings are the keys of this one.
+				if err := checkKnownFields(value, t, path, lines); err != nil {
+					return err
+				}
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				err := fmt.Errorf("unknown field %q", key.Value)
+				if path != "" {
+					err = fmt.Errorf("%s: %w", path, err)
+				}
+				if lines {
+					err = fmt.Errorf("line %d: %w", key.Line, err)
+				}
+				return err
+			}
+			if err := checkKnownFields(value, field, joinFieldPath(path, key.Value), lines); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// yamlFields returns the types of the fields of the struct type t by the keys that yaml decodes
+// them from: the name in their yaml tag, or else their name in lower case.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, flags, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(flags, "inline") && field.Type.Kind() == reflect.Struct {
+			for name, inlined := range yamlFields(field.Type) {
+				fields[name] = inlined
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
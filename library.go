@@ -0,0 +1,127 @@
+// Package minifed builds federations of OIDF entities with arbitrary layouts from a
+// configuration, see Config, and serves them, for testing the clients and entities that take part
+// in real federations. It's the library behind the minifed command, see cmd/minifed.
+//
+// To drive a federation from a Go test, build it with New, and serve its Handler with httptest:
+//
+//	cfg, err := minifed.ParseConfig("testdata/federation.yaml")
+//	...
+//	federation, err := minifed.New(cfg)
+//	...
+//	defer federation.Stop()
+//	server := httptest.NewServer(federation.Handler())
+//	defer server.Close()
+package minifed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Federation is a federation built from a configuration, for driving from Go code, such as the
+// tests of an OIDF client, rather than from the command line. It's built on BuildFederation, and
+// serves the same entities the same way, without the admin API or reloading. Several federations
+// can be live at once, e.g. in parallel tests, as long as they serve different hosts, or are routed
+// by path on different ports. Where they overlap, the requests that entities make to each other
+// while resolving trust chains are served by the most recently built federation.
+type Federation struct {
+	config   Config
+	entities map[string]*Entity
+	handler  http.Handler
+
+	mu      sync.Mutex
+	server  *http.Server
+	done    chan error
+	stopped bool
+}
+
+// New builds the federation described by cfg, without binding any ports. Serve it with Start, or
+// with a server of your own around Handler.
+func New(cfg Config) (*Federation, error) {
+	entities, handler, err := BuildFederation(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Federation{config: cfg, entities: entities, handler: handler}, nil
+}
+
+// Handler returns the mux that serves every entity of f, for use with httptest, e.g.
+//
+//	server := httptest.NewServer(federation.Handler())
+//
+// Requests are routed to entities by their Host header, or under their path prefix if path_routing
+// is set. For entity identifiers that resolve to the server, set path_routing to its URL before
+// building the federation, which httptest.NewUnstartedServer allows by binding its listener early:
+//
+//	server := httptest.NewUnstartedServer(nil)
+//	cfg.PathRouting = "http://" + server.Listener.Addr().String()
+//	federation, err := New(cfg)
+//	...
+//	server.Config.Handler = federation.Handler()
+//	server.Start()
+func (f *Federation) Handler() http.Handler {
+	return f.handler
+}
+
+// Start serves f on addr, e.g. "localhost:8080", in the background, with the timeouts and limits
+// of the server key of its configuration. It returns once addr is bound, so that f can be
+// requested right away.
+func (f *Federation) Start(addr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return errors.New("federation is stopped")
+	}
+	if f.server != nil {
+		return errors.New("federation is already started")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("federation on %s: %w", addr, err)
+	}
+	f.server = f.config.Server.newServer(ln.Addr().String(), f.handler)
+	f.done = make(chan error, 1)
+	go func() {
+		slog.Info(fmt.Sprintf("federation listening on %s", f.server.Addr))
+		f.done <- f.server.Serve(ln)
+	}()
+	return nil
+}
+
+// URL returns the identifier of the entity named name, which its entity configuration is served
+// under, or "" if f serves no entity by that name, as is the case for external entities.
+func (f *Federation) URL(name string) string {
+	entity, ok := f.entities[name]
+	if !ok || entity.External {
+		return ""
+	}
+	return entity.Identifier.String()
+}
+
+// Stop shuts down the server of f, if it was started, waiting for the requests in flight for up to
+// shutdownTimeout, and closes the storage of its entities, which can't be resolved against from
+// then on. f can't be started again afterwards, and stopping it again does nothing.
+func (f *Federation) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return nil
+	}
+	f.stopped = true
+	var err error
+	if f.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = f.server.Shutdown(ctx)
+		if serveErr := <-f.done; !errors.Is(serveErr, http.ErrServerClosed) {
+			err = errors.Join(err, serveErr)
+		}
+	}
+	closeEntities(f.entities)
+	return err
+}
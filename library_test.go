@@ -0,0 +1,161 @@
+package minifed_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/inahga/minifed"
+)
+
+const libraryConfig = `
+entities:
+  RTA:
+    kind: trust-anchor
+    identifier: https://real-ta.example.org
+    external: true
+  TA:
+    kind: trust-anchor
+  IM:
+    kind: intermediate
+  L:
+    kind: leaf
+edges:
+  - RTA -> IM -> L
+  - TA -> IM
+`
+
+func parseLibraryConfig(t *testing.T) minifed.Config {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(libraryConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := minifed.ParseConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestFederationHandler(t *testing.T) {
+	cfg := parseLibraryConfig(t)
+	server := httptest.NewUnstartedServer(nil)
+	cfg.PathRouting = "http://" + server.Listener.Addr().String()
+	federation, err := minifed.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer federation.Stop()
+	server.Config.Handler = federation.Handler()
+	server.Start()
+	defer server.Close()
+
+	leaf := federation.URL("L")
+	if want := cfg.PathRouting + "/entities/l"; leaf != want {
+		t.Fatalf("URL(L) = %q, want %q", leaf, want)
+	}
+	resp, err := http.Get(leaf + "/.well-known/openid-federation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("entity configuration of L: status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/entity-statement+jwt" {
+		t.Errorf("entity configuration of L: content type %q", got)
+	}
+
+	for _, name := range []string{"RTA", "nope"} {
+		if got := federation.URL(name); got != "" {
+			t.Errorf("URL(%s) = %q, want none", name, got)
+		}
+	}
+}
+
+func TestFederationStartStop(t *testing.T) {
+	cfg := parseLibraryConfig(t)
+	cfg.PathRouting = "http://127.0.0.1"
+	federation, err := minifed.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := federation.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := federation.Start("127.0.0.1:0"); err == nil {
+		t.Error("starting twice succeeded")
+	}
+	if err := federation.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := federation.Stop(); err != nil {
+		t.Errorf("stopping twice: %s", err)
+	}
+	if err := federation.Start("127.0.0.1:0"); err == nil {
+		t.Error("starting after stopping succeeded")
+	}
+}
+
+func TestFederationsAtOnce(t *testing.T) {
+	// Each federation is routed by path on a port of its own, so both serve the same paths, with
+	// keys of their own.
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(`
+entities:
+  TA:
+    kind: trust-anchor
+  IM:
+    kind: intermediate
+  L:
+    kind: leaf
+edges:
+  - TA -> IM -> L
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var federations []*minifed.Federation
+	for range 2 {
+		cfg, err := minifed.ParseConfig(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewUnstartedServer(nil)
+		cfg.PathRouting = "http://" + server.Listener.Addr().String()
+		federation, err := minifed.New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer federation.Stop()
+		server.Config.Handler = federation.Handler()
+		server.Start()
+		defer server.Close()
+		federations = append(federations, federation)
+	}
+
+	resolve := func(federation *minifed.Federation) int {
+		t.Helper()
+		resp, err := http.Get(federation.URL("TA") + "/resolve?sub=" + federation.URL("L") + "&trust_anchor=" + federation.URL("TA"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i, federation := range federations {
+		if status := resolve(federation); status != http.StatusOK {
+			t.Errorf("resolving L in federation %d: status %d", i, status)
+		}
+	}
+
+	// Once the latest federation is stopped, the other is still resolved against.
+	if err := federations[1].Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if status := resolve(federations[0]); status != http.StatusOK {
+		t.Errorf("resolving L once the other federation is stopped: status %d", status)
+	}
+}
@@ -0,0 +1,117 @@
+package minifed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is how long the listeners that are still up are given to finish the requests
+// in flight, once one of them has failed.
+const shutdownTimeout = 5 * time.Second
+
+// listener is a server, and the address it's bound to once listenAll succeeds.
+type listener struct {
+	// name describes what's served, for the logs.
+	name   string
+	server *http.Server
+	// certFile and keyFile make the server serve TLS, with server.TLSConfig.
+	certFile, keyFile string
+
+	ln net.Listener
+}
+
+// listenAll binds each of listeners to its address. If any of them can't be bound, the others are
+// closed, and the errors are returned naming the addresses.
+func listenAll(listeners []*listener) error {
+	var errs []error
+	for _, l := range listeners {
+		ln, err := net.Listen("tcp", l.server.Addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s on %s: %w", l.name, l.server.Addr, err))
+			continue
+		}
+		l.ln = ln
+	}
+	if err := errors.Join(errs...); err != nil {
+		for _, l := range listeners {
+			if l.ln != nil {
+				_ = l.ln.Close()
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// serveAll serves each of listeners, which must have been bound by listenAll, in its own
+// goroutine. As soon as one of them stops serving, the others are shut down, and the error of the
+// first is returned naming its address.
+func serveAll(listeners []*listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			var err error
+			if l.certFile != "" {
+				slog.Info(fmt.Sprintf("%s listening on %s, over TLS", l.name, l.server.Addr))
+				err = l.server.ServeTLS(l.ln, l.certFile, l.keyFile)
+			} else {
+				slog.Info(fmt.Sprintf("%s listening on %s", l.name, l.server.Addr))
+				err = l.server.Serve(l.ln)
+			}
+			errs <- fmt.Errorf("%s on %s: %w", l.name, l.server.Addr, err)
+		}()
+	}
+
+	err := <-errs
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, l := range listeners {
+		if shutdownErr := l.server.Shutdown(ctx); shutdownErr != nil {
+			slog.Error("failed to shut down listener", "listener", l.name, "addr", l.server.Addr, "err", shutdownErr)
+		}
+	}
+	return err
+}
+
+// limitBody refuses requests to next whose body is larger than limit with 413 Content Too Large.
+// The body is read in full before next is called, so that next sees either all of it or nothing,
+// rather than failing halfway through reading it in its own way. A negative limit disables it.
+func limitBody(next http.Handler, limit int64) http.Handler {
+	if limit < 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tooLarge := func() {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{
+				fmt.Sprintf("request body is larger than the limit of %d bytes", limit),
+			})
+		}
+		if r.ContentLength > limit {
+			tooLarge()
+			return
+		}
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			tooLarge()
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"failed to read request body: " + err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
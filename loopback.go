@@ -0,0 +1,107 @@
+package minifed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	_ "unsafe" // for go:linkname
+
+	"github.com/go-resty/resty/v2"
+)
+
+// oidfedClient is the client go-oidfed uses for all of its outbound requests, e.g. fetching
+// entity configurations and subordinate statements while resolving trust chains. It isn't
+// exported, so reach in for it so that those requests can be routed back into this process.
+//
+//go:linkname oidfedClient github.com/zachmann/go-oidfed/internal/http.client
+var oidfedClient *resty.Client
+
+// loopback is the transport of oidfedClient, once a federation has been built.
+var loopback loopbackTransport
+
+// loopbackRoutes are the hosts that a federation serves, and the handler that serves them.
+type loopbackRoutes struct {
+	handler http.Handler
+	hosts   map[string]bool
+	// pathRouting is set if the federation is routed by path, in which case its hosts include the
+	// port number, so that federations served on different ports of a host can be told apart.
+	pathRouting bool
+	// next sends the requests for hosts that no federation serves.
+	next http.RoundTripper
+}
+
+// key returns the form of host that r serves it by.
+func (r *loopbackRoutes) key(host string) string {
+	if r.pathRouting {
+		return strings.ToLower(host)
+	}
+	return routeHost(host) // n.b. the port number is ignored, as with normal routing
+}
+
+// loopbackTransport is an http.RoundTripper that serves requests for locally served hosts
+// directly from the handler of the federation that serves them, much like the Host header routing
+// that a client of minifed would do. This sidesteps the need for name resolution and TLS for
+// entities in the federation. go-oidfed's client is shared by the whole process, so every live
+// federation registers its hosts with the one transport, and unregisters them once closed. If
+// several serve a host, the most recently built one serves it. Requests for any other host are
+// sent with the next transport of the most recently built federation, so with its retries.
+type loopbackTransport struct {
+	install sync.Once
+	mu      sync.RWMutex
+	// routes are those of the live federations, in the order they were built.
+	routes []*loopbackRoutes
+}
+
+// register serves the hosts of routes with its handler, until unregistered.
+func (t *loopbackTransport) register(routes *loopbackRoutes) {
+	t.install.Do(func() { oidfedClient.SetTransport(traceTransport(t)) })
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = append(t.routes, routes)
+}
+
+// unregister stops serving the hosts of routes, if they're still registered.
+func (t *loopbackTransport) unregister(routes *loopbackRoutes) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = slices.DeleteFunc(t.routes, func(r *loopbackRoutes) bool { return r == routes })
+}
+
+// lookup returns the handler that serves requests for host, or nil if no federation serves it,
+// along with the transport that sends requests for hosts that aren't served.
+func (t *loopbackTransport) lookup(host string) (http.Handler, http.RoundTripper) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.routes) == 0 {
+		return nil, newRetryTransport(RetryConfig{}, http.DefaultTransport)
+	}
+	for _, routes := range slices.Backward(t.routes) {
+		if routes.hosts[routes.key(host)] {
+			return routes.handler, t.routes[len(t.routes)-1].next
+		}
+	}
+	return nil, t.routes[len(t.routes)-1].next
+}
+
+func (t *loopbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	handler, next := t.lookup(req.URL.Host)
+	if handler == nil {
+		return next.RoundTrip(req)
+	}
+
+	inbound := req.Clone(req.Context())
+	inbound.Host = routeHost(req.URL.Host)
+	inbound.RequestURI = req.URL.RequestURI()
+	inbound.RemoteAddr = "127.0.0.1:0"
+	if inbound.Body == nil {
+		inbound.Body = http.NoBody
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, inbound)
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
@@ -0,0 +1,45 @@
+package minifed
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLoopbackOfClosedFederation(t *testing.T) {
+	_, one := buildTestFederation(t, testChain)
+	cfg, err := ParseConfig(writeTestConfig(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.two.example.com
+  L:
+    kind: leaf
+    identifier: https://l.two.example.com
+edges:
+  - TA -> L
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, two, err := BuildFederation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, handler := range map[string]http.Handler{
+		"https://ta.example.com/resolve?sub=https://l.example.com&trust_anchor=https://ta.example.com":             one,
+		"https://ta.two.example.com/resolve?sub=https://l.two.example.com&trust_anchor=https://ta.two.example.com": two,
+	} {
+		if resp := get(t, handler, target); resp.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", target, resp.Code, resp.Body)
+		}
+	}
+
+	// The hosts of a closed federation aren't served anymore, but those of the other still are.
+	closeEntities(entities)
+	if handler, _ := loopback.lookup("ta.two.example.com"); handler != nil {
+		t.Error("ta.two.example.com is still served once its federation is closed")
+	}
+	if handler, _ := loopback.lookup("ta.example.com:443"); handler == nil {
+		t.Error("ta.example.com isn't served once another federation is closed")
+	}
+}
@@ -0,0 +1,145 @@
+package minifed
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+// logResolvedMetadata logs the metadata in the responses of the resolve endpoint of e at debug
+// level, which has the metadata policies of the selected chain applied. It returns next as is if
+// debug logging is disabled.
+func logResolvedMetadata(e *Entity, next http.Handler) http.Handler {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+
+		if recorder.Code != http.StatusOK {
+			return
+		}
+		payload, ok := jwtPayload(recorder.Body.Bytes())
+		if !ok {
+			return
+		}
+		var claims struct {
+			Metadata json.RawMessage `json:"metadata"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			slog.Debug("failed to decode resolve response", "entity", e.Name, "err", err)
+			return
+		}
+		query := r.URL.Query()
+		slog.Debug("resolved metadata", "entity", e.Name, "sub", query.Get("sub"),
+			"trust_anchor", query["trust_anchor"], "metadata", string(claims.Metadata))
+	})
+}
+
+// policyStepsResponse shows how the metadata of a subject is transformed by the metadata policies
+// of each trust chain between it and a set of trust anchors.
+type policyStepsResponse struct {
+	Subject      string   `json:"sub"`
+	TrustAnchors []string `json:"anchor"`
+	// Metadata is the subject's metadata as published in its entity configuration.
+	Metadata *oidcfed.Metadata `json:"metadata,omitempty"`
+	Chains   []policyChain     `json:"chains"`
+}
+
+type policyChain struct {
+	// Path is the list of entities in the chain, from the subject to the trust anchor.
+	Path []string `json:"path"`
+	// Selected is set on the chain that the resolve endpoint would use, as in /verify.
+	Selected bool `json:"selected"`
+	// Steps are the subordinate statements of the chain, from the one issued by the trust anchor
+	// down to the one about the subject.
+	Steps []policyStep `json:"steps"`
+}
+
+type policyStep struct {
+	Issuer  string `json:"iss"`
+	Subject string `json:"sub"`
+	// Policy is the metadata policy of this statement alone.
+	Policy *oidcfed.MetadataPolicies `json:"metadata_policy,omitempty"`
+	// Combined is the policy of this statement merged with those of the statements above it.
+	Combined *oidcfed.MetadataPolicies `json:"combined_policy,omitempty"`
+	// Metadata is the subject's metadata with the combined policy applied. That of the last step
+	// is the metadata that the resolve endpoint serves.
+	Metadata *oidcfed.Metadata `json:"metadata,omitempty"`
+	// Error is why the policy couldn't be merged or applied. The steps end at the first error.
+	Error string `json:"error,omitempty"`
+}
+
+// handlePolicySteps resolves the trust chains between the sub query parameter and each anchor
+// query parameter, like handleVerify, and reports the metadata of sub after each hop's metadata
+// policy is applied, from the trust anchor down.
+func handlePolicySteps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sub := query.Get("sub")
+	anchors := query["anchor"]
+	if sub == "" || len(anchors) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"required parameters 'sub' and 'anchor' not given"})
+		return
+	}
+
+	resolver := oidcfed.TrustResolver{
+		TrustAnchors:   oidcfed.NewTrustAnchorsFromEntityIDs(anchors...),
+		StartingEntity: sub,
+		Types:          query["entity_type"],
+	}
+	chains := resolver.ResolveToValidChainsWithoutVerifyingMetadata()
+
+	response := policyStepsResponse{Subject: sub, TrustAnchors: anchors, Chains: []policyChain{}}
+	for _, chain := range chains {
+		// Every chain begins with the same entity configuration of the subject.
+		response.Metadata = chain[0].Metadata
+		traced := policyChain{Steps: []policyStep{}}
+		for _, statement := range chain {
+			traced.Path = append(traced.Path, statement.Issuer)
+		}
+		metadata := chain[0].Metadata
+		if metadata == nil {
+			metadata = &oidcfed.Metadata{}
+		}
+		policies := make([]*oidcfed.MetadataPolicies, len(chain))
+		for j, statement := range chain {
+			policies[j] = statement.MetadataPolicy
+		}
+		// The chain begins with the subject's entity configuration, followed by the subordinate
+		// statements about each entity issued by its superior, ending with the trust anchor's.
+		for hop := len(chain) - 1; hop >= 1; hop-- {
+			step := policyStep{
+				Issuer:  chain[hop].Issuer,
+				Subject: chain[hop].Subject,
+				Policy:  chain[hop].MetadataPolicy,
+			}
+			combined, err := oidcfed.MergeMetadataPolicies(policies[hop:]...)
+			if err == nil {
+				step.Combined = combined
+				step.Metadata, err = metadata.ApplyPolicy(combined)
+			}
+			if err != nil {
+				step.Error = err.Error()
+			}
+			traced.Steps = append(traced.Steps, step)
+			if err != nil {
+				break
+			}
+		}
+		response.Chains = append(response.Chains, traced)
+	}
+	if selected := selectChain(chains); selected >= 0 {
+		response.Chains[selected].Selected = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,235 @@
+package minifed
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchInterval is how long -watch waits for the configuration to stay the same before reloading
+// it, and how often it polls the configuration if it can't be watched.
+const watchInterval = 500 * time.Millisecond
+
+// swappableHandler serves requests with a handler that can be replaced while serving.
+type swappableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.handler.Load()).ServeHTTP(w, r)
+}
+
+func (h *swappableHandler) swap(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+// reloader builds the federation of a configuration file, and builds it anew when asked to,
+// swapping the new federation in for the one being served. Only the entities and edges are
+// reloaded, along with everything about them. The listeners keep the addresses, timeouts, and TLS
+// configuration that they were started with.
+type reloader struct {
+	filename string
+	// pathRouting overrides path_routing in the configuration, if set.
+	pathRouting string
+	// newAdminHandler returns the handler of the admin API for a federation, or nil if the admin
+	// API isn't served.
+	newAdminHandler func(entities map[string]*Entity) http.Handler
+
+	federation, admin swappableHandler
+
+	mu       sync.Mutex
+	config   Config
+	entities map[string]*Entity
+}
+
+// parse reads the configuration file of r.
+func (r *reloader) parse() (Config, error) {
+	config, err := ParseConfig(r.filename)
+	if err != nil {
+		return Config{}, err
+	}
+	if r.pathRouting != "" {
+		config.PathRouting = r.pathRouting
+	}
+	return config, nil
+}
+
+// load builds the federation of config, and serves it in place of the current one.
+func (r *reloader) load(config Config) error {
+	entities, handler, err := BuildFederation(config)
+	if err != nil {
+		return err
+	}
+	r.config, r.entities = config, entities
+	r.federation.swap(handler)
+	if r.newAdminHandler != nil {
+		r.admin.swap(r.newAdminHandler(entities))
+	}
+	return nil
+}
+
+// reload builds the federation of the configuration file anew, and serves it in place of the
+// current one, returning the number of entities served. If it can't be built, the current one
+// keeps being served.
+func (r *reloader) reload() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config, err := r.parse()
+	if err != nil {
+		return 0, err
+	}
+	previous, entities := r.config, r.entities
+	if previous.Storage.Dir == "" {
+		if err := r.load(config); err != nil {
+			return 0, err
+		}
+		// Give the requests in flight to the current federation time to finish before closing it.
+		time.AfterFunc(shutdownTimeout, func() { closeEntities(entities) })
+		return len(r.entities), nil
+	}
+
+	// Storage on disk can only be opened by one database at a time, so the current federation
+	// has to be closed first. If the new one can't be built, the current one is built again from
+	// what it left on disk.
+	closeEntities(entities)
+	if err := r.load(config); err != nil {
+		if restoreErr := r.load(previous); restoreErr != nil {
+			return 0, errors.Join(err, restoreErr)
+		}
+		return 0, err
+	}
+	return len(r.entities), nil
+}
+
+// reloadOn reloads the federation whenever a value is received from trigger, logging the
+// outcome.
+func (r *reloader) reloadOn(trigger <-chan string) {
+	for reason := range trigger {
+		slog.Info("reloading federation", "reason", reason, "config", r.filename)
+		entities, err := r.reload()
+		if err != nil {
+			slog.Error("failed to reload federation, the previous one is still served", "err", err)
+			continue
+		}
+		slog.Info("reloaded federation", "entities", entities)
+	}
+}
+
+// notifyHangup sends on trigger whenever the process receives SIGHUP.
+func notifyHangup(trigger chan<- string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		trigger <- "SIGHUP"
+	}
+}
+
+// watch sends on trigger whenever any of filenames change, once they've stayed the same for a
+// whole interval, so that a file that's still being written isn't loaded. The directories holding
+// the files are watched with filesystem notifications, rather than the files themselves, so that
+// replacing a file by renaming another over it, as editors do when saving, doesn't drop the watch,
+// and so that missing files are noticed when they appear. If the directories can't be watched,
+// e.g. on a platform fsnotify doesn't support or when one of them is missing, the files are
+// polled every interval instead, see poll.
+func watch(filenames []string, interval time.Duration, trigger chan<- string) {
+	watcher, watched, err := watchDirs(filenames)
+	if err != nil {
+		slog.Warn("can't watch the config for changes, polling it instead", "err", err)
+		poll(filenames, interval, trigger)
+		return
+	}
+	defer watcher.Close()
+
+	// Events for other files in the directories, such as the symlinks that Kubernetes swaps to
+	// update a mounted ConfigMap, only count if the files changed.
+	last, changed := fileStates(filenames), false
+	settled := time.NewTimer(interval)
+	settled.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			changed = changed || watched[filepath.Clean(event.Name)]
+			settled.Reset(interval)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been dropped, e.g. if the queue of the kernel overflowed.
+			slog.Warn("failed to watch the config for changes", "err", err)
+			changed = true
+			settled.Reset(interval)
+		case <-settled.C:
+			if current := fileStates(filenames); changed || !slices.Equal(last, current) {
+				last, changed = current, false
+				trigger <- "config changed"
+			}
+		}
+	}
+}
+
+// watchDirs returns a watcher of the directories holding filenames, along with the absolute
+// paths of the files, which name the events about them.
+func watchDirs(filenames []string) (*fsnotify.Watcher, map[string]bool, error) {
+	watched := map[string]bool{}
+	for _, filename := range filenames {
+		path, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		watched[path] = true
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, path := range slices.Sorted(maps.Keys(watched)) {
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+			return nil, nil, fmt.Errorf("%s: %w", filepath.Dir(path), err)
+		}
+	}
+	return watcher, watched, nil
+}
+
+// poll is watch by polling filenames for their size and modification time every interval.
+func poll(filenames []string, interval time.Duration, trigger chan<- string) {
+	last, changed := fileStates(filenames), false
+	for range time.Tick(interval) {
+		if current := fileStates(filenames); !slices.Equal(last, current) {
+			last, changed = current, true
+			continue
+		}
+		if changed {
+			changed = false
+			trigger <- "config changed"
+		}
+	}
+}
+
+// fileStates returns the size and modification time of each of filenames, or that it's missing.
+func fileStates(filenames []string) []string {
+	var states []string
+	for _, filename := range filenames {
+		state := "missing"
+		if info, err := os.Stat(filename); err == nil {
+			state = fmt.Sprint(info.ModTime(), info.Size())
+		}
+		states = append(states, state)
+	}
+	return states
+}
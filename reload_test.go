@@ -0,0 +1,157 @@
+package minifed
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchDebounce(t *testing.T) {
+	for name, watch := range map[string]func([]string, time.Duration, chan<- string){"notify": watch, "poll": poll} {
+		t.Run(name, func(t *testing.T) { testWatchDebounce(t, watch) })
+	}
+}
+
+func testWatchDebounce(t *testing.T, watch func([]string, time.Duration, chan<- string)) {
+	const interval = 50 * time.Millisecond
+	dir := t.TempDir()
+	written, missing := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "trust.csv")
+	if err := os.WriteFile(written, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	trigger := make(chan string, 10)
+	go watch([]string{written, missing}, interval, trigger)
+	time.Sleep(2 * interval)
+
+	// A file that keeps being written to isn't reloaded until it's left alone, and then only once.
+	file, err := os.OpenFile(written, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for deadline := time.Now().Add(6 * interval); time.Now().Before(deadline); time.Sleep(interval / 10) {
+		if _, err := file.WriteString("#\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(trigger) > 0 {
+		t.Fatalf("reloaded %d times while the file was being written", len(trigger))
+	}
+	select {
+	case <-trigger:
+	case <-time.After(10 * interval):
+		t.Fatal("not reloaded once the file was left alone")
+	}
+	time.Sleep(4 * interval)
+	if len(trigger) > 0 {
+		t.Errorf("reloaded %d more times once the file was left alone", len(trigger))
+	}
+
+	// Missing files are reloaded when they appear.
+	if err := os.WriteFile(missing, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-trigger:
+	case <-time.After(10 * interval):
+		t.Fatal("not reloaded once the missing file appeared")
+	}
+
+	// Saving by renaming another file over one doesn't stop it from being watched.
+	for i := range 2 {
+		temporary := filepath.Join(dir, ".config.yaml.swp")
+		if err := os.WriteFile(temporary, []byte(strings.Repeat("#\n", i+1)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(temporary, written); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-trigger:
+		case <-time.After(10 * interval):
+			t.Fatalf("not reloaded once another file was renamed over the config, save %d", i+1)
+		}
+	}
+}
+
+func TestWatchMissingDirectory(t *testing.T) {
+	// watch falls back to polling the files in a directory that doesn't exist yet.
+	const interval = 50 * time.Millisecond
+	dir := filepath.Join(t.TempDir(), "later")
+	trigger := make(chan string, 10)
+	go watch([]string{filepath.Join(dir, "config.yaml")}, interval, trigger)
+	time.Sleep(2 * interval)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-trigger:
+	case <-time.After(10 * interval):
+		t.Fatal("not reloaded once the config appeared in a new directory")
+	}
+}
+
+func TestReloadSwap(t *testing.T) {
+	const config = `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> L
+`
+	filename := writeTestConfig(t, config)
+	r := &reloader{filename: filename}
+	initial, err := r.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.load(initial); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeEntities(r.entities) })
+	if resp := get(t, &r.federation, "https://m.example.com/.well-known/openid-federation"); resp.Code != http.StatusNotFound {
+		t.Fatalf("M is served before it's configured: status %d", resp.Code)
+	}
+
+	extended := strings.Replace(config, "edges:", `  M:
+    kind: leaf
+    identifier: https://m.example.com
+edges:
+  - TA -> M`, 1)
+	if err := os.WriteFile(filename, []byte(extended), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entities, err := r.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entities != 3 {
+		t.Errorf("reloaded %d entities, want 3", entities)
+	}
+	for _, id := range []string{"https://m.example.com", "https://l.example.com"} {
+		if resp := get(t, &r.federation, id+"/.well-known/openid-federation"); resp.Code != http.StatusOK {
+			t.Errorf("%s after reloading: status %d: %s", id, resp.Code, resp.Body)
+		}
+	}
+
+	// A configuration that can't be built leaves the current federation served.
+	if err := os.WriteFile(filename, []byte("edges:\n  - TA -> nope\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reload(); err == nil {
+		t.Fatal("reloaded a configuration with an unknown entity")
+	}
+	if resp := get(t, &r.federation, "https://m.example.com/.well-known/openid-federation"); resp.Code != http.StatusOK {
+		t.Errorf("M after failing to reload: status %d: %s", resp.Code, resp.Body)
+	}
+}
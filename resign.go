@@ -0,0 +1,122 @@
+package minifed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+// resign signs the payload of jwt anew with the key id and clock offset of e, keeping the other
+// header parameters. go-oidfed always puts the thumbprint of the key in the header, and takes the
+// time of the statements from the system clock. jwt is returned as is if e has neither configured.
+func (e *Entity) resign(jwt []byte) ([]byte, error) {
+	kid := e.headerKID()
+	if kid == "" && e.ClockOffset == 0 {
+		return jwt, nil
+	}
+	message, err := jws.Parse(jwt)
+	if err != nil {
+		return nil, err
+	}
+	if len(message.Signatures()) != 1 {
+		return nil, fmt.Errorf("expected 1 signature, found %d", len(message.Signatures()))
+	}
+	protected := message.Signatures()[0].ProtectedHeaders()
+	headers := jws.NewHeaders()
+	if err := protected.Copy(context.Background(), headers); err != nil {
+		return nil, err
+	}
+	if kid != "" {
+		if err := headers.Set(jws.KeyIDKey, kid); err != nil {
+			return nil, err
+		}
+	}
+	payload := message.Payload()
+	if e.ClockOffset != 0 {
+		if payload, err = shiftTimes(payload, e.ClockOffset); err != nil {
+			return nil, err
+		}
+	}
+	return jws.Sign(payload, protected.Algorithm(), e.SigningPrivateKey, jws.WithHeaders(headers))
+}
+
+// signWithFaultAlg signs the payload of jwt anew with the fault signing key of e, keeping the
+// header parameters of jwt other than the algorithm, so that it no longer matches the JWKS of e.
+func (e *Entity) signWithFaultAlg(jwt []byte) ([]byte, error) {
+	message, err := jws.Parse(jwt)
+	if err != nil {
+		return nil, err
+	}
+	headers := jws.NewHeaders()
+	if err := message.Signatures()[0].ProtectedHeaders().Copy(context.Background(), headers); err != nil {
+		return nil, err
+	}
+	return jws.Sign(message.Payload(), jwa.SignatureAlgorithm(e.Key.FaultAlg), e.FaultSigningKey, jws.WithHeaders(headers))
+}
+
+// timeClaims are the claims shifted by a clock offset.
+var timeClaims = []string{"iat", "exp", "nbf"}
+
+// shiftTimes moves the timeClaims of the JSON payload by offset. Claims that are absent or zero,
+// meaning there is no expiry, are left as they are.
+func shiftTimes(payload []byte, offset time.Duration) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var claims map[string]any
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, err
+	}
+	for _, name := range timeClaims {
+		value, ok := claims[name].(json.Number)
+		if !ok {
+			continue
+		}
+		seconds, err := value.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if seconds != 0 {
+			claims[name] = seconds + offset.Seconds()
+		}
+	}
+	return json.Marshal(claims)
+}
+
+// resignHandler signs the JWTs served by next anew with the key id and clock offset of e, see
+// resign. It's for the endpoints served by go-oidfed.
+func resignHandler(e *Entity, next http.Handler) http.Handler {
+	if e.headerKID() == "" && e.ClockOffset == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body must be left uncompressed to be signed anew.
+		r = r.Clone(r.Context())
+		r.Header.Del("Accept-Encoding")
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		body := recorder.Body.Bytes()
+		if strings.HasSuffix(recorder.Header().Get("Content-Type"), "jwt") {
+			jwt, err := e.resign(bytes.TrimSpace(body))
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+				return
+			}
+			body = jwt
+		}
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(body)
+	})
+}
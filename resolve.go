@@ -0,0 +1,108 @@
+package minifed
+
+import (
+	"net/http"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+	"github.com/zachmann/go-oidfed/pkg/constants"
+	"github.com/zachmann/go-oidfed/pkg/unixtime"
+)
+
+// resolveHandler serves the resolve endpoint of entity. It behaves like go-oidfed's resolve
+// endpoint, except that chains that violate the constraints of their statements, or hold statements
+// that aren't valid now, are rejected, which go-oidfed doesn't check, see chainError, and that
+// delegated trust marks are kept.
+func resolveHandler(entity *Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		sub, anchors := query.Get("sub"), query["trust_anchor"]
+		if len(anchors) == 0 {
+			writeJSON(w, http.StatusBadRequest, oidcfed.ErrorInvalidRequest("required parameter 'trust_anchor' not given"))
+			return
+		}
+		if sub == "" {
+			writeJSON(w, http.StatusBadRequest, oidcfed.ErrorInvalidRequest("required parameter 'sub' not given"))
+			return
+		}
+		resolver := oidcfed.TrustResolver{
+			TrustAnchors:   oidcfed.NewTrustAnchorsFromEntityIDs(anchors...),
+			StartingEntity: sub,
+			Types:          query["entity_type"],
+		}
+		chains := resolver.ResolveToValidChainsWithoutVerifyingMetadata()
+		if len(chains) == 0 {
+			writeJSON(w, http.StatusNotFound, oidcfed.ErrorInvalidTrustChain("no valid trust path between sub and anchor found"))
+			return
+		}
+		chains = chains.Filter(oidcfed.TrustChainsFilterValidMetadata)
+		if len(chains) == 0 {
+			writeJSON(w, http.StatusNotFound, oidcfed.ErrorInvalidMetadata("no trust path with valid metadata found between sub and anchor"))
+			return
+		}
+		selected := selectChain(chains)
+		if selected < 0 {
+			writeJSON(w, http.StatusNotFound, oidcfed.ErrorInvalidTrustChain(
+				"no trust path between sub and anchor satisfies the constraints and validity of its statements: "+chainError(chains[0]).Error(),
+			))
+			return
+		}
+
+		chain := chains[selected]
+		metadata, _ := chain.Metadata()
+		// Go-oidfed verifies the trust marks of the leaf against the last statement of the chain,
+		// which is the trust anchor's statement about its subordinate rather than its entity
+		// configuration, so every delegated trust mark is left out for want of its trust_mark_owners.
+		anchor, err := oidcfed.GetEntityConfiguration(chain[len(chain)-1].Issuer)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, oidcfed.ErrorInvalidTrustChain("entity configuration of trust anchor: "+err.Error()))
+			return
+		}
+		response := oidcfed.ResolveResponse{
+			Issuer:    entity.Identifier.String(),
+			Subject:   sub,
+			IssuedAt:  unixtime.Unixtime{Time: time.Now()},
+			ExpiresAt: chain.ExpiresAt(),
+			ResolveResponsePayload: oidcfed.ResolveResponsePayload{
+				Metadata:   metadata,
+				TrustMarks: chain[0].TrustMarks.VerifiedFederation(&anchor.EntityStatementPayload),
+				TrustChain: chain.Messages(),
+			},
+		}
+		jwt, err := entity.FedEntity.GeneralJWTSigner.ResolveResponseSigner().JWT(response)
+		if err == nil {
+			jwt, err = entity.resign(jwt)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, oidcfed.ErrorServerError(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", constants.ContentTypeResolveResponse)
+		_, _ = w.Write(jwt)
+	}
+}
+
+// chainError reports why chain can't be used, beyond the signatures and issuers of its statements,
+// which go-oidfed checks while resolving it: the metadata policies of its statements must apply to
+// the metadata of its subject, it must satisfy their constraints, and they must be valid now.
+func chainError(chain oidcfed.TrustChain) error {
+	if _, err := chain.Metadata(); err != nil {
+		return err
+	}
+	if err := checkConstraints(chain); err != nil {
+		return err
+	}
+	return checkTimes(chain)
+}
+
+// selectChain returns the index of the chain among chains that the resolve endpoint uses, or -1 if
+// none can be used, see chainError. Like go-oidfed, it selects the first of the shortest chains.
+func selectChain(chains oidcfed.TrustChains) int {
+	selected := -1
+	for i, chain := range chains {
+		if chainError(chain) == nil && (selected < 0 || len(chain) < len(chains[selected])) {
+			selected = i
+		}
+	}
+	return selected
+}
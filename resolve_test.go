@@ -0,0 +1,109 @@
+package minifed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveAllowedEntityTypes(t *testing.T) {
+	for _, test := range []struct {
+		allowed string
+		valid   bool
+	}{
+		{allowed: "openid_relying_party", valid: false},
+		{allowed: "openid_provider", valid: true},
+	} {
+		t.Run(test.allowed, func(t *testing.T) {
+			_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  OP:
+    kind: leaf
+    identifier: https://op.example.com
+    openid_provider: true
+edges:
+  - edge: TA -> OP
+    allowed_entity_types: [`+test.allowed+`]
+`)
+			resp := get(t, handler, "https://ta.example.com/resolve?sub=https://op.example.com&trust_anchor=https://ta.example.com")
+			if test.valid && resp.Code != http.StatusOK {
+				t.Errorf("resolve: status %d: %s", resp.Code, resp.Body)
+			}
+			if !test.valid {
+				var body struct {
+					Error string `json:"error"`
+				}
+				_ = json.Unmarshal(resp.Body.Bytes(), &body)
+				if resp.Code != http.StatusNotFound || body.Error != "invalid_trust_chain" {
+					t.Errorf("resolve: status %d: %s, want invalid_trust_chain", resp.Code, resp.Body)
+				}
+			}
+
+			recorder := httptest.NewRecorder()
+			handleVerify(recorder, httptest.NewRequest(http.MethodGet, "/verify?sub=https://op.example.com&anchor=https://ta.example.com", nil))
+			var verified verifyResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &verified); err != nil {
+				t.Fatal(err)
+			}
+			if verified.Valid != test.valid {
+				t.Errorf("verify: valid is %t, want %t: %s", verified.Valid, test.valid, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestResolveExpiredStatements(t *testing.T) {
+	// The clock offset of each entity sets back what it signs by a year, so that its statements
+	// expired long ago. go-oidfed drops the chains whose trust anchor's entity configuration is
+	// expired, but not those with other statements that are.
+	for _, test := range []struct {
+		expired string
+		found   bool
+	}{
+		{expired: "L", found: true},
+		{expired: "TA", found: false},
+	} {
+		t.Run(test.expired, func(t *testing.T) {
+			config := map[string]string{"TA": "", "L": ""}
+			config[test.expired] = "\n    clock_offset: -8760h10m"
+			_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com`+config["TA"]+`
+  L:
+    kind: leaf
+    identifier: https://l.example.com`+config["L"]+`
+edges:
+  - TA -> L
+`)
+			resp := get(t, handler, "https://ta.example.com/resolve?sub=https://l.example.com&trust_anchor=https://ta.example.com")
+			if resp.Code == http.StatusOK {
+				t.Errorf("resolve: status %d, though a statement of the chain is expired", resp.Code)
+			}
+
+			recorder := httptest.NewRecorder()
+			handleVerify(recorder, httptest.NewRequest(http.MethodGet, "/verify?sub=https://l.example.com&anchor=https://ta.example.com", nil))
+			var verified verifyResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &verified); err != nil {
+				t.Fatal(err)
+			}
+			if verified.Valid {
+				t.Errorf("verify: valid, though a statement of the chain is expired: %s", recorder.Body)
+			}
+			if found := len(verified.Chains) > 0; found != test.found {
+				t.Errorf("verify: found chains is %t, want %t: %s", found, test.found, recorder.Body)
+			}
+			for _, chain := range verified.Chains {
+				if !strings.HasSuffix(chain.TimeError, " is expired") {
+					t.Errorf("verify: chain %v has the time error %q", chain.Path, chain.TimeError)
+				}
+			}
+		})
+	}
+}
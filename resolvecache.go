@@ -0,0 +1,124 @@
+package minifed
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// resolveCache caches the responses of an entity's resolve endpoint, keyed by query. An entry is
+// kept for the TTL of the cache, or until the resolve response expires if that's sooner.
+type resolveCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]resolveCacheEntry
+}
+
+type resolveCacheEntry struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newResolveCache(ttl time.Duration) *resolveCache {
+	return &resolveCache{ttl: ttl, entries: map[string]resolveCacheEntry{}}
+}
+
+// clear empties the cache, and returns the number of entries that were in it.
+func (c *resolveCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = map[string]resolveCacheEntry{}
+	return n
+}
+
+// handler serves requests from the cache, falling back to next. Only successful responses are
+// cached. Whether a request was a hit is recorded on its span.
+func (c *resolveCache) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Encoding sorts the parameters by name, so equivalent queries share an entry.
+		key := r.URL.Query().Encode()
+		span := trace.SpanFromContext(r.Context())
+
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		if ok && time.Now().After(entry.expires) {
+			delete(c.entries, key)
+			ok = false
+		}
+		c.mu.Unlock()
+		if ok {
+			span.SetAttributes(attribute.Bool("minifed.resolve_cache.hit", true))
+			slog.Debug("resolve cache hit", "host", r.Host, "query", key)
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(entry.body)
+			return
+		}
+		span.SetAttributes(attribute.Bool("minifed.resolve_cache.hit", false))
+
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+
+		if recorder.Code != http.StatusOK {
+			return
+		}
+		expires := time.Now().Add(c.ttl)
+		if exp, ok := jwtExpiry(recorder.Body.Bytes()); ok && exp.Before(expires) {
+			expires = exp
+		}
+		c.mu.Lock()
+		c.entries[key] = resolveCacheEntry{
+			header:  recorder.Header().Clone(),
+			body:    recorder.Body.Bytes(),
+			expires: expires,
+		}
+		c.mu.Unlock()
+	})
+}
+
+// jwtExpiry returns the time at which the JWT jwt expires, if it has an exp claim. The signature
+// isn't checked.
+func jwtExpiry(jwt []byte) (time.Time, bool) {
+	payload, ok := jwtPayload(jwt)
+	if !ok {
+		return time.Time{}, false
+	}
+	var claims struct {
+		ExpiresAt float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(claims.ExpiresAt*float64(time.Second))), true
+}
+
+// jwtPayload returns the decoded payload of the JWT jwt, without checking its signature.
+func jwtPayload(jwt []byte) ([]byte, bool) {
+	parts := strings.Split(string(jwt), ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	return payload, true
+}
@@ -0,0 +1,33 @@
+package minifed
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestResolveCacheHit(t *testing.T) {
+	entities, handler := buildTestFederation(t, "resolve_cache_ttl: 1m\n"+testChain)
+	resolve := func(query string) []byte {
+		t.Helper()
+		resp := get(t, handler, "https://ta.example.com/resolve?"+query)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("resolve: status %d: %s", resp.Code, resp.Body)
+		}
+		return resp.Body.Bytes()
+	}
+
+	first := resolve("sub=https://l.example.com&trust_anchor=https://ta.example.com")
+	// The parameters are in another order, but the query is the same.
+	second := resolve("trust_anchor=https://ta.example.com&sub=https://l.example.com")
+	if !bytes.Equal(first, second) {
+		t.Error("second resolve wasn't served from the cache")
+	}
+	if n := entities["TA"].ResolveCache.clear(); n != 1 {
+		t.Errorf("cache held %d entries, want 1", n)
+	}
+	// Each response is signed with a fresh iat, so a miss differs.
+	if third := resolve("sub=https://l.example.com&trust_anchor=https://ta.example.com"); bytes.Equal(first, third) {
+		t.Error("resolve after clearing the cache was served from it")
+	}
+}
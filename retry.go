@@ -0,0 +1,97 @@
+package minifed
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Defaults of RetryConfig.
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 2 * time.Second
+)
+
+// RetryConfig configures retrying the requests that minifed makes to entities it doesn't serve
+// itself, e.g. while resolving trust chains, when they fail to connect. This rides out listeners
+// that aren't ready yet. The backoff between attempts starts at Backoff, written as a duration
+// such as "100ms", and doubles after each retry up to MaxBackoff. Retries never outlast the
+// deadline of the request.
+type RetryConfig struct {
+	// MaxRetries is the number of times a request is retried. A negative number disables
+	// retries.
+	MaxRetries int           `yaml:"max_retries"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+func (r *RetryConfig) setDefaults() {
+	if r.MaxRetries == 0 {
+		r.MaxRetries = DefaultMaxRetries
+	}
+	if r.Backoff == 0 {
+		r.Backoff = DefaultBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = DefaultMaxBackoff
+	}
+}
+
+func (r RetryConfig) validate() error {
+	if r.Backoff < 0 || r.MaxBackoff < 0 {
+		return errors.New("retry: backoff must be positive")
+	}
+	return nil
+}
+
+// retryTransport is an http.RoundTripper that retries requests sent with next that fail without a
+// response, per config.
+type retryTransport struct {
+	config RetryConfig
+	next   http.RoundTripper
+}
+
+func newRetryTransport(config RetryConfig, next http.RoundTripper) http.RoundTripper {
+	config.setDefaults()
+	if config.MaxRetries < 0 {
+		return next
+	}
+	return retryTransport{config: config, next: next}
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.config.Backoff
+	for retry := 0; ; retry++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || retry == t.config.MaxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		// A request with a body can only be sent again if the body can be read again.
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < backoff {
+			return resp, err
+		}
+
+		slog.Debug("retrying request", "url", req.URL.String(), "retry", retry+1, "backoff", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, t.config.MaxBackoff)
+	}
+}
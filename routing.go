@@ -0,0 +1,94 @@
+package minifed
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+// PathRoutingPrefix is the path under which entities are served in path routing mode, followed by
+// their lowercased name, e.g. /entities/ta/.well-known/openid-federation.
+const PathRoutingPrefix = "/entities/"
+
+// pathRoutingIdentifier returns the identifier of the entity named name, when served in path
+// routing mode under base.
+func pathRoutingIdentifier(base, name string) (string, error) {
+	return url.JoinPath(base, PathRoutingPrefix, strings.ToLower(name))
+}
+
+// route returns what requests for e are routed by: the host of its identifier followed by its
+// path, if it has one, or in path routing mode, where all entities share a host, the path of its
+// identifier.
+func (e *Entity) route(pathRouting bool) string {
+	if pathRouting {
+		return e.routePath()
+	}
+	return routeHost(e.Identifier.Host) + e.routePath() // n.b. the port number is ignored
+}
+
+// routePath returns the path of the identifier of e, without a trailing slash, which its endpoints
+// are served under.
+func (e *Entity) routePath() string {
+	return strings.TrimSuffix(e.Identifier.EscapedPath(), "/")
+}
+
+// routeHost returns the host that requests for host are routed by: its name or IP address,
+// lowercased, without the port number or the brackets around an IPv6 address. A Host header of
+// [::1]:8443, [::1], or ::1 are all routed to the entity identified by https://[::1]:8443.
+func routeHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+}
+
+// hostRouting rewrites the Host header of requests to the form routeHost returns before passing
+// them on to handler, since http.ServeMux only strips the port number from it, and doesn't
+// recognize a bracketed IPv6 address without one.
+func hostRouting(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Host = routeHost(r.Host)
+		handler.ServeHTTP(w, r2)
+	})
+}
+
+// stripPrefix is like http.StripPrefix, but also strips prefix from the request URI, which
+// go-oidfed routes on rather than the URL path.
+func stripPrefix(prefix string, handler http.Handler) http.Handler {
+	stripped := http.StripPrefix(prefix, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.RequestURI = strings.TrimPrefix(r.RequestURI, prefix)
+		stripped.ServeHTTP(w, r2)
+	})
+}
+
+// routingMiss is the response to requests that aren't routed to any entity.
+type routingMiss struct {
+	oidcfed.Error
+	// Entities are the names of the entities served, keyed by the route they're served at.
+	Entities map[string]string `json:"entities"`
+}
+
+// routingMissHandler answers requests that aren't routed to any of the entities in servedBy,
+// listing the routes that are served, since the likely cause is a mistyped Host header or path.
+func routingMissHandler(servedBy map[string]*Entity, pathRouting bool) http.Handler {
+	entities := map[string]string{}
+	for route, entity := range servedBy {
+		entities[route] = entity.Name
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		description := fmt.Sprintf("no entity is served at host %q and path %q, set the Host header to the host of an entity", r.Host, r.URL.Path)
+		if pathRouting {
+			description = fmt.Sprintf("no entity is served at path %q, entities are served under %s", r.URL.Path, PathRoutingPrefix)
+		}
+		writeJSON(w, http.StatusNotFound, routingMiss{oidcfed.ErrorNotFound(description), entities})
+	})
+}
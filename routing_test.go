@@ -0,0 +1,126 @@
+package minifed
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRouteHost(t *testing.T) {
+	for _, test := range []struct{ host, want string }{
+		{"ta.example.com", "ta.example.com"},
+		{"TA.Example.com:8443", "ta.example.com"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"127.0.0.1:8080", "127.0.0.1"},
+		{"[::1]:8443", "::1"},
+		{"[::1]", "::1"},
+		{"::1", "::1"},
+		{"[2001:DB8::1]:443", "2001:db8::1"},
+	} {
+		if got := routeHost(test.host); got != test.want {
+			t.Errorf("routeHost(%q) = %q, want %q", test.host, got, test.want)
+		}
+	}
+}
+
+func TestHostRoutingIPLiterals(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: http://127.0.0.1:8080
+  L:
+    kind: leaf
+    identifier: http://[::1]:8443
+edges:
+  - TA -> L
+`)
+	for _, target := range []string{
+		"http://127.0.0.1:8080/.well-known/openid-federation",
+		"http://127.0.0.1/.well-known/openid-federation",
+		"http://[::1]:8443/.well-known/openid-federation",
+		"http://[::1]/.well-known/openid-federation",
+	} {
+		if resp := get(t, handler, target); resp.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d: %s", target, resp.Code, resp.Body)
+		}
+	}
+	_, claims := entityConfiguration(t, handler, "http://[::1]:8443")
+	if claims["sub"] != "http://[::1]:8443" {
+		t.Errorf("entity configuration served for [::1] has the sub %v", claims["sub"])
+	}
+	resp := get(t, handler, "http://127.0.0.1:8080/fetch?sub=http://[::1]:8443")
+	if resp.Code != http.StatusOK || decodeJWT(t, resp.Body.Bytes())["sub"] != "http://[::1]:8443" {
+		t.Errorf("fetching the statement about L: status %d: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestPathIdentifiers(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://example.com
+  IM:
+    kind: intermediate
+    identifier: https://example.com/im
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> IM -> L
+`)
+	for _, id := range []string{"https://example.com", "https://example.com/im"} {
+		if _, claims := entityConfiguration(t, handler, id); claims["sub"] != id {
+			t.Errorf("entity configuration served for %s has the sub %v", id, claims["sub"])
+		}
+	}
+	resp := get(t, handler, "https://example.com/im/fetch?sub=https://l.example.com")
+	if resp.Code != http.StatusOK || decodeJWT(t, resp.Body.Bytes())["iss"] != "https://example.com/im" {
+		t.Errorf("fetch from IM: status %d: %s", resp.Code, resp.Body)
+	}
+	resp = get(t, handler, "https://example.com/resolve?sub=https://l.example.com&trust_anchor=https://example.com")
+	if resp.Code != http.StatusOK {
+		t.Errorf("resolve through IM: status %d: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestPathRouting(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+path_routing: http://localhost:8080
+entities:
+  TA:
+    kind: trust-anchor
+  IM:
+    kind: intermediate
+  L:
+    kind: leaf
+edges:
+  - TA -> IM -> L
+`)
+	const base = "http://localhost:8080/entities/"
+	_, claims := entityConfiguration(t, handler, base+"l")
+	if claims["sub"] != base+"l" || string(claimJSON(t, claims["authority_hints"])) != `["`+base+`im"]` {
+		t.Errorf("entity configuration of L has the sub %v and authority_hints %v", claims["sub"], claims["authority_hints"])
+	}
+	resp := get(t, handler, base+"im/fetch?sub="+base+"l")
+	if resp.Code != http.StatusOK || decodeJWT(t, resp.Body.Bytes())["iss"] != base+"im" {
+		t.Errorf("fetch from IM: status %d: %s", resp.Code, resp.Body)
+	}
+	resp = get(t, handler, base+"ta/resolve?sub="+base+"l&trust_anchor="+base+"ta")
+	if resp.Code != http.StatusOK {
+		t.Errorf("resolve through IM: status %d: %s", resp.Code, resp.Body)
+	}
+
+	// Every entity shares the host, whatever the Host header, and the paths that aren't routed to
+	// an entity list the ones that are.
+	if resp := get(t, handler, "http://other.example.com/entities/ta/.well-known/openid-federation"); resp.Code != http.StatusOK {
+		t.Errorf("entity configuration of TA on another host: status %d", resp.Code)
+	}
+	resp = get(t, handler, base+"nope/.well-known/openid-federation")
+	var miss routingMiss
+	_ = json.Unmarshal(resp.Body.Bytes(), &miss)
+	if resp.Code != http.StatusNotFound || len(miss.Entities) != 3 || miss.Entities["/entities/ta"] != "TA" {
+		t.Errorf("unrouted path: status %d: %s", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,216 @@
+package minifed
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	oidcfed "github.com/zachmann/go-oidfed/pkg"
+)
+
+// StatusFault is a testing-only fault: requests to an endpoint are answered with an error status
+// rather than served, for checking that clients retry or give up as they should.
+type StatusFault struct {
+	// Status is the HTTP status to answer with, e.g. 500, 503, or 429.
+	Status int
+	// Fraction is the fraction of requests to fail, between 0 and 1, picked at random. Unset fails
+	// every request, and 0 none of them.
+	Fraction *float64
+	// RetryAfter is sent as the Retry-After header of the failed responses, written as a duration
+	// such as "5s", and rounded up to whole seconds. Unset leaves the header out.
+	RetryAfter time.Duration `yaml:"retry_after"`
+	// Disabled leaves the fault off at startup, for turning it on later with the admin API.
+	Disabled bool
+}
+
+func (f StatusFault) validate() error {
+	if f.Status < 400 || f.Status > 599 {
+		return fmt.Errorf("status must be between 400 and 599, not %d", f.Status)
+	}
+	if fraction := f.fraction(); fraction < 0 || fraction > 1 {
+		return fmt.Errorf("fraction must be between 0 and 1, not %v", fraction)
+	}
+	if f.RetryAfter < 0 {
+		return errors.New("retry_after must be positive")
+	}
+	return nil
+}
+
+// fraction returns the fraction of requests that f fails.
+func (f StatusFault) fraction() float64 {
+	if f.Fraction == nil {
+		return 1
+	}
+	return *f.Fraction
+}
+
+// statusFaultState is a status fault as the admin API reports it.
+type statusFaultState struct {
+	Status     int     `json:"status"`
+	Fraction   float64 `json:"fraction"`
+	RetryAfter string  `json:"retry_after,omitempty"`
+	Enabled    bool    `json:"enabled"`
+}
+
+func (f StatusFault) state() statusFaultState {
+	state := statusFaultState{Status: f.Status, Fraction: f.fraction(), Enabled: !f.Disabled}
+	if f.RetryAfter > 0 {
+		state.RetryAfter = f.RetryAfter.String()
+	}
+	return state
+}
+
+// statusFaults are the status faults of an entity by endpoint name, which can be changed while
+// it's served.
+type statusFaults struct {
+	mu     sync.Mutex
+	faults map[string]StatusFault
+}
+
+// get returns the fault of endpoint, if it has one.
+func (s *statusFaults) get(endpoint string) (StatusFault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fault, ok := s.faults[endpoint]
+	return fault, ok
+}
+
+// set replaces the fault of endpoint.
+func (s *statusFaults) set(endpoint string, fault StatusFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.faults == nil {
+		s.faults = map[string]StatusFault{}
+	}
+	s.faults[endpoint] = fault
+}
+
+// all returns a copy of the faults.
+func (s *statusFaults) all() map[string]StatusFault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.faults)
+}
+
+// statusFaultHandler answers the requests to the endpoints of e that have an enabled status fault
+// with its status, for the configured fraction of them, and passes the rest on to next. A fault
+// of an endpoint whose path ends in a slash covers every path below it.
+func statusFaultHandler(e *Entity, next http.Handler) http.Handler {
+	endpoints := map[string]string{}
+	for name, path := range e.endpointPaths() {
+		endpoints[path] = name
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := endpoints[r.URL.Path]
+		if !ok {
+			for path, name := range endpoints {
+				if strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path) {
+					endpoint = name
+					break
+				}
+			}
+		}
+		fault, ok := e.statusFaults.get(endpoint)
+		if !ok || fault.Disabled || rand.Float64() >= fault.fraction() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.Debug("failing request with status fault", "entity", e.Name, "endpoint", endpoint, "status", fault.Status)
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(fault.RetryAfter.Seconds()))))
+		}
+		description := fmt.Sprintf("status fault of %s: %s", endpoint, http.StatusText(fault.Status))
+		switch {
+		case fault.Status == http.StatusTooManyRequests || fault.Status == http.StatusServiceUnavailable:
+			writeJSON(w, fault.Status, oidcfed.ErrorTemporarilyUnavailable(description))
+		case fault.Status >= 500:
+			writeJSON(w, fault.Status, oidcfed.ErrorServerError(description))
+		default:
+			writeJSON(w, fault.Status, oidcfed.ErrorInvalidRequest(description))
+		}
+	})
+}
+
+// statusFaultsHandler lists the status faults of every entity by name if it's a GET, or else sets
+// the one of the entity and endpoint query parameters from the status, fraction, retry_after,
+// and enabled query parameters, keeping what isn't given of the fault it replaces. Endpoints
+// without a fault can be given one, as long as a status is given.
+func statusFaultsHandler(entities map[string]*Entity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			faults := map[string]map[string]statusFaultState{}
+			for name, entity := range entities {
+				for endpoint, fault := range entity.statusFaults.all() {
+					if faults[name] == nil {
+						faults[name] = map[string]statusFaultState{}
+					}
+					faults[name][endpoint] = fault.state()
+				}
+			}
+			writeJSON(w, http.StatusOK, faults)
+			return
+		}
+
+		query := r.URL.Query()
+		name, endpoint := query.Get("entity"), query.Get("endpoint")
+		if name == "" || endpoint == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"required parameters 'entity' and 'endpoint' not given"})
+			return
+		}
+		entity := lookupEntity(entities, name)
+		if entity == nil || entity.External {
+			writeJSON(w, http.StatusNotFound, errorResponse{"no entity is named " + name})
+			return
+		}
+		paths := entity.endpointPaths()
+		if _, ok := paths[endpoint]; !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{fmt.Sprintf(
+				"%s doesn't serve %s, it serves %s", entity.Name, endpoint, strings.Join(slices.Sorted(maps.Keys(paths)), ", "),
+			)})
+			return
+		}
+
+		fault, ok := entity.statusFaults.get(endpoint)
+		if !ok && !query.Has("status") {
+			writeJSON(w, http.StatusBadRequest, errorResponse{endpoint + " has no status fault, so 'status' must be given"})
+			return
+		}
+		var err error
+		if query.Has("status") {
+			fault.Status, err = strconv.Atoi(query.Get("status"))
+		}
+		if err == nil && query.Has("fraction") {
+			var fraction float64
+			fraction, err = strconv.ParseFloat(query.Get("fraction"), 64)
+			fault.Fraction = &fraction
+		}
+		if err == nil && query.Has("retry_after") {
+			fault.RetryAfter, err = time.ParseDuration(query.Get("retry_after"))
+		}
+		if err == nil && query.Has("enabled") {
+			var enabled bool
+			enabled, err = strconv.ParseBool(query.Get("enabled"))
+			fault.Disabled = !enabled
+		}
+		if err == nil {
+			err = fault.validate()
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		entity.statusFaults.set(endpoint, fault)
+		slog.Info("set status fault", "entity", entity.Name, "endpoint", endpoint, "status", fault.Status,
+			"fraction", fault.fraction(), "enabled", !fault.Disabled)
+		writeJSON(w, http.StatusOK, fault.state())
+	}
+}
@@ -0,0 +1,129 @@
+package minifed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestStatusFault(t *testing.T) {
+	entities, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+    status_faults:
+      fetch:
+        status: 503
+        retry_after: 1500ms
+      list:
+        status: 500
+        fraction: 0
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> L
+`)
+	resp := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com")
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(resp.Body.Bytes(), &body)
+	if resp.Code != http.StatusServiceUnavailable || body.Error != "temporarily_unavailable" {
+		t.Errorf("fetch: status %d: %s, want 503 and temporarily_unavailable", resp.Code, resp.Body)
+	}
+	if retryAfter := resp.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("fetch: Retry-After is %q, want 1500ms rounded up to 2", retryAfter)
+	}
+	// A fraction of 0 fails none of the requests.
+	if resp := get(t, handler, "https://ta.example.com/list"); resp.Code != http.StatusOK {
+		t.Errorf("list: status %d: %s, though its fault has a fraction of 0", resp.Code, resp.Body)
+	}
+
+	admin := newAdminHandler(entities)
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status-faults?entity=TA&endpoint=fetch&fraction=0", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("setting the fraction of the fault of fetch to 0: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if resp := get(t, handler, "https://ta.example.com/fetch?sub=https://l.example.com"); resp.Code != http.StatusOK {
+		t.Errorf("fetch: status %d: %s, though its fault has a fraction of 0", resp.Code, resp.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status-faults", nil))
+	var faults map[string]map[string]statusFaultState
+	if err := json.Unmarshal(recorder.Body.Bytes(), &faults); err != nil {
+		t.Fatal(err)
+	}
+	want := statusFaultState{Status: http.StatusServiceUnavailable, Fraction: 0, RetryAfter: "1.5s", Enabled: true}
+	if got := faults["TA"]["fetch"]; got != want {
+		t.Errorf("status fault of fetch is %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveThroughStatusFault(t *testing.T) {
+	// go-oidfed's client only retries requests that fail without a response, as does minifed's
+	// transport beneath it, so an error status from any endpoint along the chain fails resolution,
+	// and Retry-After is left to whoever drives the client.
+	for _, status := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			entities, handler := buildTestFederation(t, fmt.Sprintf(`
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  IM:
+    kind: intermediate
+    identifier: https://im.example.com
+    status_faults:
+      fetch:
+        status: %d
+        retry_after: 3s
+  L:
+    kind: leaf
+    identifier: https://l.example.com
+edges:
+  - TA -> IM
+  - IM -> L
+`, status))
+			verify := func() verifyResponse {
+				recorder := httptest.NewRecorder()
+				handleVerify(recorder, httptest.NewRequest(http.MethodGet, "/verify?sub=https://l.example.com&anchor=https://ta.example.com", nil))
+				var verified verifyResponse
+				if err := json.Unmarshal(recorder.Body.Bytes(), &verified); err != nil {
+					t.Fatal(err)
+				}
+				return verified
+			}
+
+			if resp := get(t, handler, "https://ta.example.com/resolve?sub=https://l.example.com&trust_anchor=https://ta.example.com"); resp.Code == http.StatusOK {
+				t.Errorf("resolve: status %d, though the fetch endpoint of IM fails", resp.Code)
+			}
+			if verified := verify(); verified.Valid || len(verified.Chains) != 0 {
+				t.Errorf("verify: found chains, though the fetch endpoint of IM fails: %+v", verified)
+			}
+
+			// The client gets the status and Retry-After of the fault as served.
+			resp, err := oidfedClient.R().Get("https://im.example.com/fetch?sub=https://l.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode() != status || resp.Header().Get("Retry-After") != "3" {
+				t.Errorf("fetch through the client of go-oidfed: status %d, Retry-After %q, want %d and 3", resp.StatusCode(), resp.Header().Get("Retry-After"), status)
+			}
+
+			// Once the fault is lifted, the chain resolves again.
+			if resp := adminRequest(t, entities, http.MethodPost, "/status-faults?entity=IM&endpoint=fetch&fraction=0"); resp.Code != http.StatusOK {
+				t.Fatalf("lifting the fault of fetch: status %d: %s", resp.Code, resp.Body)
+			}
+			if verified := verify(); !verified.Valid {
+				t.Errorf("verify: no valid chain, though the fault of IM was lifted: %+v", verified)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package minifed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/zachmann/go-oidfed/pkg/fedentities/storage"
+)
+
+// Defaults for the garbage collection of the value logs of on-disk storage, as go-oidfed runs it.
+const (
+	DefaultGCInterval     = 5 * time.Minute
+	DefaultGCDiscardRatio = 0.7
+)
+
+// StorageConfig tunes the Badger databases that entities keep their subordinates and trust marked
+// entities in. Unset sizes take on Badger's defaults.
+type StorageConfig struct {
+	// Dir keeps the databases on disk, in a directory per entity and database under Dir, rather
+	// than in memory. They're opened as they were left by the last run, so records of entities
+	// and edges that have since been removed remain.
+	Dir string
+	// MemTableSize is the size of each memtable, in bytes. Every database holds a few in memory,
+	// so this dominates the memory used by large federations.
+	MemTableSize int64 `yaml:"mem_table_size"`
+	// ValueLogFileSize is the size of each value log file on disk, in bytes.
+	ValueLogFileSize int64 `yaml:"value_log_file_size"`
+	// GCInterval is how often the value logs on disk are garbage collected, written as a duration
+	// such as "1m", rewriting the files of which at least GCDiscardRatio can be discarded. A
+	// negative interval disables garbage collection.
+	GCInterval     time.Duration `yaml:"gc_interval"`
+	GCDiscardRatio float64       `yaml:"gc_discard_ratio"`
+}
+
+func (s *StorageConfig) setDefaults() {
+	if s.GCInterval == 0 {
+		s.GCInterval = DefaultGCInterval
+	}
+	if s.GCDiscardRatio == 0 {
+		s.GCDiscardRatio = DefaultGCDiscardRatio
+	}
+}
+
+func (s StorageConfig) validate() error {
+	if s.MemTableSize < 0 || s.ValueLogFileSize < 0 {
+		return errors.New("storage: sizes must be positive")
+	}
+	if s.GCDiscardRatio < 0 || s.GCDiscardRatio >= 1 {
+		return errors.New("storage: gc_discard_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// open opens the database of entity named name, in memory unless Dir is set.
+func (s StorageConfig) open(entity *Entity, name string) (*storage.BadgerStorage, error) {
+	s.setDefaults()
+	options := badger.DefaultOptions("").WithInMemory(true)
+	var dir string
+	if s.Dir != "" {
+		dir = filepath.Join(s.Dir, url.PathEscape(entity.Name), name)
+		options = badger.DefaultOptions(dir)
+		if s.ValueLogFileSize > 0 {
+			options = options.WithValueLogFileSize(s.ValueLogFileSize)
+		}
+	}
+	if s.MemTableSize > 0 {
+		// Badger refuses values larger than a batch, which is a fraction of a memtable, from being
+		// kept inline, so keep fewer of them inline.
+		options = options.WithMemTableSize(s.MemTableSize).
+			WithValueThreshold(min(options.ValueThreshold, s.MemTableSize*15/100))
+	}
+	db, err := badger.Open(options.WithLogger(badgerLogger{slog.With("entity", entity.Name, "storage", name)}))
+	if err != nil {
+		return nil, err
+	}
+	// go-oidfed's constructors can't take options, but its storage only needs the database. The
+	// path is only used to open the database, which is already open.
+	store := &storage.BadgerStorage{DB: db, Path: dir}
+	if dir != "" && s.GCInterval > 0 {
+		go s.collectGarbage(entity, name, db)
+	}
+	return store, nil
+}
+
+// badgerLogger logs the messages of Badger with slog. Badger logs every database it opens and
+// closes at its info level, which is only of interest when debugging, so those are logged at debug
+// level, and only its warnings and errors are logged as such.
+type badgerLogger struct {
+	logger *slog.Logger
+}
+
+func (l badgerLogger) log(level slog.Level, format string, args []any) {
+	l.logger.Log(context.Background(), level, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (l badgerLogger) Errorf(format string, args ...any)   { l.log(slog.LevelError, format, args) }
+func (l badgerLogger) Warningf(format string, args ...any) { l.log(slog.LevelWarn, format, args) }
+func (l badgerLogger) Infof(format string, args ...any)    { l.log(slog.LevelDebug, format, args) }
+func (l badgerLogger) Debugf(format string, args ...any)   { l.log(slog.LevelDebug, format, args) }
+
+// collectGarbage garbage collects the value logs of db every GCInterval, until it's closed.
+func (s StorageConfig) collectGarbage(entity *Entity, name string, db *badger.DB) {
+	ticker := time.NewTicker(s.GCInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// Each run rewrites at most one file, so keep going until there's nothing left to rewrite.
+		for {
+			err := db.RunValueLogGC(s.GCDiscardRatio)
+			if errors.Is(err, badger.ErrNoRewrite) {
+				break
+			}
+			// Garbage collection is rejected once the database is closing.
+			if errors.Is(err, badger.ErrDBClosed) || errors.Is(err, badger.ErrRejected) {
+				return
+			}
+			if err != nil {
+				slog.Error("failed to garbage collect storage", "entity", entity.Name, "storage", name, "err", err)
+				break
+			}
+		}
+	}
+}
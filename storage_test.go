@@ -0,0 +1,56 @@
+package minifed
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStorageLogsQuietly(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(defaultLogger)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	// Both storage in memory and on disk is opened and closed.
+	for _, dir := range []string{"", "\nstorage:\n  dir: " + t.TempDir()} {
+		cfg, err := ParseConfig(writeTestConfig(t, testChain+dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entities, _, err := BuildFederation(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeEntities(entities)
+	}
+	w.Close()
+	written, _ := io.ReadAll(r)
+	if len(written) > 0 {
+		t.Errorf("storage wrote to stderr:\n%s", written)
+	}
+
+	var badger int
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if !strings.Contains(line, " storage=") {
+			continue
+		}
+		badger++
+		if !strings.Contains(line, "level=DEBUG") {
+			t.Errorf("storage logged above debug level: %s", line)
+		}
+	}
+	if badger == 0 {
+		t.Error("storage logged nothing at debug level")
+	}
+}
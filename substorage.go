@@ -0,0 +1,144 @@
+package minifed
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/zachmann/go-oidfed/pkg/fedentities/storage"
+)
+
+// filteringSubordinateStorage is subordinate storage whose queries apply their filters. go-oidfed's
+// Badger storage ignores the filters of its queries, so every subordinate would be listed
+// regardless of its status or the entity_type parameter. It also reports unknown subordinates as
+// absent, rather than failing to read them.
+type filteringSubordinateStorage struct {
+	storage.SubordinateStorageBackend
+}
+
+func (s filteringSubordinateStorage) Subordinate(entityID string) (*storage.SubordinateInfo, error) {
+	info, err := s.SubordinateStorageBackend.Subordinate(entityID)
+	// go-oidfed's Badger storage drops the distinction between a missing record and a failed read,
+	// leaving only the message of the error.
+	if err != nil && strings.HasSuffix(err.Error(), "' not found") {
+		return nil, nil
+	}
+	return info, err
+}
+
+func (s filteringSubordinateStorage) Active() storage.SubordinateStorageQuery {
+	return newFilteredQuery(s.SubordinateStorageBackend.Active(), storage.StatusActive)
+}
+
+func (s filteringSubordinateStorage) Blocked() storage.SubordinateStorageQuery {
+	return newFilteredQuery(s.SubordinateStorageBackend.Blocked(), storage.StatusBlocked)
+}
+
+func (s filteringSubordinateStorage) Pending() storage.SubordinateStorageQuery {
+	return newFilteredQuery(s.SubordinateStorageBackend.Pending(), storage.StatusPending)
+}
+
+type filteredQuery struct {
+	query   storage.SubordinateStorageQuery
+	filters []func(info storage.SubordinateInfo) bool
+}
+
+func newFilteredQuery(query storage.SubordinateStorageQuery, status storage.Status) *filteredQuery {
+	return &filteredQuery{
+		query: query,
+		filters: []func(info storage.SubordinateInfo) bool{
+			func(info storage.SubordinateInfo) bool { return info.Status == status },
+		},
+	}
+}
+
+func (q *filteredQuery) Subordinates() ([]storage.SubordinateInfo, error) {
+	infos, err := q.query.Subordinates()
+	if err != nil {
+		return nil, err
+	}
+	var filtered []storage.SubordinateInfo
+outer:
+	for _, info := range infos {
+		for _, filter := range q.filters {
+			if !filter(info) {
+				continue outer
+			}
+		}
+		filtered = append(filtered, info)
+	}
+	return filtered, nil
+}
+
+func (q *filteredQuery) EntityIDs() ([]string, error) {
+	infos, err := q.Subordinates()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.EntityID)
+	}
+	return ids, nil
+}
+
+func (q *filteredQuery) AddFilter(filter storage.SubordinateStorageQueryFilter, value any) error {
+	q.filters = append(q.filters, func(info storage.SubordinateInfo) bool {
+		return filter(info, value)
+	})
+	return nil
+}
+
+// storedSubordinates returns the records in the subordinate storage of entity, of every status,
+// sorted by entity id. The storage can't be iterated as a whole, so these are the subordinates of
+// the entity, along with any others that can be queried by status.
+func storedSubordinates(entity *Entity) ([]storage.SubordinateInfo, error) {
+	store := entity.Storage.SubordinateStorage()
+	ids := map[string]bool{}
+	for _, subordinate := range entity.Subordinates {
+		ids[subordinate.Identifier.String()] = true
+	}
+	filtering := filteringSubordinateStorage{store}
+	for _, query := range []storage.SubordinateStorageQuery{
+		filtering.Active(), filtering.Blocked(), filtering.Pending(),
+	} {
+		queried, err := query.EntityIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range queried {
+			ids[id] = true
+		}
+	}
+
+	var infos []storage.SubordinateInfo
+	for id := range ids {
+		// Subordinates that weren't seeded have no record, which filtering reports as absent.
+		info, err := filtering.Subordinate(id)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			infos = append(infos, *info)
+		}
+	}
+	slices.SortFunc(infos, func(a, b storage.SubordinateInfo) int {
+		return strings.Compare(a.EntityID, b.EntityID)
+	})
+	return infos, nil
+}
+
+// statusName returns the name of status, as written in the status of an edge.
+func statusName(status storage.Status) string {
+	switch status {
+	case storage.StatusActive:
+		return "active"
+	case storage.StatusBlocked:
+		return "blocked"
+	case storage.StatusPending:
+		return "pending"
+	case storage.StatusInactive:
+		return "inactive"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,132 @@
+package minifed
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTracing installs an OpenTelemetry tracer provider that exports spans to the OTLP/HTTP
+// collector at endpoint (e.g. localhost:4318). If endpoint is empty, the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_EXPORTER_OTLP_TRACES_ENDPOINT environment variables are
+// consulted. If neither is set, tracing stays disabled and the global no-op provider is used.
+func setupTracing(ctx context.Context, endpoint string) error {
+	var opts []otlptracehttp.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	} else if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	installTracing(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("minifed"))),
+	))
+	return nil
+}
+
+// installTracing makes provider the global tracer provider, and propagates trace context in the
+// headers of requests, which is how the hops served by the loopback transport nest under their
+// caller.
+func installTracing(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+}
+
+// traceHandler wraps handler so that each request gets a server span. Trace context is extracted
+// from the incoming request, so requests made by the loopback transport nest under their caller.
+func traceHandler(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, "minifed", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			return r.Host + r.URL.Path
+		},
+	))
+}
+
+// traceTransport wraps transport so that each outbound request gets a client span, and the trace
+// context is injected into the request headers. Requests made while serving a handler wrapped by
+// traceHops are parented to the span of the request it's serving, see hopTransport.
+func traceTransport(transport http.RoundTripper) http.RoundTripper {
+	return hopTransport{otelhttp.NewTransport(transport)}
+}
+
+// hopSpans are the spans of the requests being served by traceHops, by the goroutine serving
+// them.
+var (
+	hopSpans     sync.Map // uint64 -> trace.Span
+	hopSpanCount atomic.Int64
+)
+
+// traceHops wraps handler so that the requests it makes while serving a request are nested under
+// the span of that request, once they reach traceTransport.
+//
+// go-oidfed discards the request context when handling requests, and makes its outbound requests
+// without one, so there's no context to carry the span through. It does make them from the
+// goroutine serving the request, though, so the span is looked up by goroutine instead.
+func traceHops(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if !span.SpanContext().IsValid() {
+			// Tracing is disabled.
+			handler.ServeHTTP(w, r)
+			return
+		}
+		id := goroutineID()
+		hopSpans.Store(id, span)
+		hopSpanCount.Add(1)
+		defer func() {
+			hopSpans.Delete(id)
+			hopSpanCount.Add(-1)
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// hopTransport parents the requests that have no span of their own to the span that traceHops
+// recorded for the goroutine making them, if any, before passing them on to next.
+type hopTransport struct {
+	next http.RoundTripper
+}
+
+func (t hopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if hopSpanCount.Load() > 0 && !trace.SpanContextFromContext(req.Context()).IsValid() {
+		if span, ok := hopSpans.Load(goroutineID()); ok {
+			// The span itself is carried rather than its span context, since otelhttp starts the
+			// client span with the tracer provider of its parent.
+			req = req.WithContext(trace.ContextWithSpan(req.Context(), span.(trace.Span)))
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// goroutineID returns the id of the calling goroutine, which the runtime only reveals in stack
+// traces.
+func goroutineID() uint64 {
+	var buf [64]byte
+	stack := buf[:runtime.Stack(buf[:], false)]
+	// The trace begins with "goroutine 123 [running]:".
+	field, _, _ := bytes.Cut(bytes.TrimPrefix(stack, []byte("goroutine ")), []byte(" "))
+	id, _ := strconv.ParseUint(string(field), 10, 64)
+	return id
+}
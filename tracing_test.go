@@ -0,0 +1,70 @@
+package minifed
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestResolveHopsNestUnderResolve(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	installTracing(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		_ = provider.Shutdown(context.Background())
+	})
+	_, handler := buildTestFederation(t, testChain)
+
+	resp := get(t, handler, "https://ta.example.com/resolve?sub=https://l.example.com&trust_anchor=https://ta.example.com")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("resolve: status %d: %s", resp.Code, resp.Body)
+	}
+
+	spans := exporter.GetSpans()
+	var resolve tracetest.SpanStub
+	for _, span := range spans {
+		if span.Name == "ta.example.com/resolve" {
+			resolve = span
+		}
+	}
+	if !resolve.SpanContext.IsValid() {
+		t.Fatalf("no span of the resolve request among %d spans", len(spans))
+	}
+	if resolve.Parent.IsValid() {
+		t.Errorf("resolve span has a parent %s", resolve.Parent.SpanID())
+	}
+	byID := map[trace.SpanID]tracetest.SpanStub{}
+	for _, span := range spans {
+		byID[span.SpanContext.SpanID()] = span
+	}
+
+	// The hops fetch the entity configurations of the leaf, the intermediate, and the trust
+	// anchor, and the statements about the leaf and the intermediate from their superiors. With the
+	// statement cache disabled, see TestMain, that of the trust anchor is fetched again to verify the
+	// trust marks of the leaf.
+	var hops []string
+	for _, span := range spans {
+		if span.SpanKind != trace.SpanKindServer || span.SpanContext.SpanID() == resolve.SpanContext.SpanID() {
+			continue
+		}
+		hops = append(hops, span.Name)
+		if span.SpanContext.TraceID() != resolve.SpanContext.TraceID() {
+			t.Errorf("hop %s is in trace %s, not that of resolve", span.Name, span.SpanContext.TraceID())
+			continue
+		}
+		client, ok := byID[span.Parent.SpanID()]
+		if !ok || client.SpanKind != trace.SpanKindClient || client.Parent.SpanID() != resolve.SpanContext.SpanID() {
+			t.Errorf("hop %s isn't served for a request made by resolve", span.Name)
+		}
+	}
+	if len(hops) != 6 {
+		t.Errorf("resolve made %d hops, want 6: %v", len(hops), hops)
+	}
+}
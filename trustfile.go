@@ -0,0 +1,155 @@
+package minifed
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Columns of a CSV trust file. Every column but superior and subordinate is optional, and lists
+// are separated by spaces, but for metadata_policy and metadata_policy_crit, which are JSON, as
+// their claims are written in subordinate statements.
+var trustFileColumns = []string{
+	"superior",
+	"subordinate",
+	"status",
+	"allowed_entity_types",
+	"max_path_length",
+	"permitted",
+	"excluded",
+	"cross_federation",
+	"seed",
+	"metadata_policy",
+	"metadata_policy_crit",
+}
+
+// mergeTrustFile appends the edges of the trust file to the top-level ones.
+func (c *Config) mergeTrustFile() error {
+	if c.TrustFile == "" {
+		return nil
+	}
+	edges, err := readTrustFile(c.TrustFile)
+	if err != nil {
+		return fmt.Errorf("trust file %s: %w", c.TrustFile, err)
+	}
+	c.Edges = append(c.Edges, edges...)
+	c.TrustFile = ""
+	return nil
+}
+
+// readTrustFile reads the edges listed in filename. Files with a .csv extension have a header row
+// naming trustFileColumns, and a row per relationship. Anything else is a list of edges written as
+// under the edges key of the configuration, in YAML or JSON per its extension.
+func readTrustFile(filename string) ([]Edge, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	lines := true
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return parseTrustCSV(bytes.NewReader(content))
+	case ".json":
+		if content, err = jsonToYAML(content); err != nil {
+			return nil, err
+		}
+		lines = false
+	}
+	var edges []Edge
+	if err := decodeStrict(content, &edges, lines); err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
+
+func parseTrustCSV(r io.Reader) ([]Edge, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	// Trailing optional columns can be left off a row.
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, column := range header {
+		if !slices.Contains(trustFileColumns, column) {
+			return nil, fmt.Errorf("unknown column %q, must be one of %v", column, trustFileColumns)
+		}
+	}
+	for _, required := range trustFileColumns[:2] {
+		if !slices.Contains(header, required) {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	var edges []Edge
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return edges, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) > len(header) {
+			return nil, fmt.Errorf("line %d: %d fields, but only %d columns", line, len(record), len(header))
+		}
+		row := map[string]string{}
+		for i, value := range record {
+			row[header[i]] = strings.TrimSpace(value)
+		}
+
+		edge := Edge{
+			Edge:               row["superior"] + " -> " + row["subordinate"],
+			Status:             row["status"],
+			AllowedEntityTypes: strings.Fields(row["allowed_entity_types"]),
+		}
+		if value := row["max_path_length"]; value != "" {
+			if edge.MaxPathLength, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("line %d: max_path_length: %w", line, err)
+			}
+		}
+		if row["permitted"] != "" || row["excluded"] != "" {
+			edge.NamingConstraints = &NamingConstraints{
+				Permitted: strings.Fields(row["permitted"]),
+				Excluded:  strings.Fields(row["excluded"]),
+			}
+		}
+		if value := row["cross_federation"]; value != "" {
+			if edge.CrossFederation, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("line %d: cross_federation: %w", line, err)
+			}
+		}
+		if value := row["seed"]; value != "" {
+			seed, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: seed: %w", line, err)
+			}
+			edge.Seed = &seed
+		}
+		if value := row["metadata_policy"]; value != "" {
+			if err := json.Unmarshal([]byte(value), &edge.MetadataPolicy); err != nil {
+				return nil, fmt.Errorf("line %d: metadata_policy: %w", line, err)
+			}
+		}
+		if value := row["metadata_policy_crit"]; value != "" {
+			if err := json.Unmarshal([]byte(value), &edge.MetadataPolicyCrit); err != nil {
+				return nil, fmt.Errorf("line %d: metadata_policy_crit: %w", line, err)
+			}
+		}
+		edges = append(edges, edge)
+	}
+}
@@ -0,0 +1,37 @@
+package minifed
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrustCSVMetadataPolicy(t *testing.T) {
+	edges, err := parseTrustCSV(strings.NewReader(`superior,subordinate,metadata_policy,metadata_policy_crit
+TA,IM,"{""openid_relying_party"": {""grant_types"": {""subset_of"": [""authorization_code""]}}}","[""subset_of""]"
+IM,L
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edges) != 2 {
+		t.Fatalf("%d edges, want 2", len(edges))
+	}
+	policy := map[string]map[string]map[string]any{
+		"openid_relying_party": {"grant_types": {"subset_of": []any{"authorization_code"}}},
+	}
+	if !reflect.DeepEqual(edges[0].MetadataPolicy, policy) {
+		t.Errorf("metadata_policy of TA -> IM is %v, want %v", edges[0].MetadataPolicy, policy)
+	}
+	if !reflect.DeepEqual(edges[0].MetadataPolicyCrit, []string{"subset_of"}) {
+		t.Errorf("metadata_policy_crit of TA -> IM is %v", edges[0].MetadataPolicyCrit)
+	}
+	if edges[1].MetadataPolicy != nil || edges[1].MetadataPolicyCrit != nil {
+		t.Errorf("IM -> L has metadata_policy %v, metadata_policy_crit %v", edges[1].MetadataPolicy, edges[1].MetadataPolicyCrit)
+	}
+
+	_, err = parseTrustCSV(strings.NewReader("superior,subordinate,metadata_policy\nTA,IM,subset_of\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2: metadata_policy") {
+		t.Errorf("parsing a metadata_policy that isn't JSON: %v", err)
+	}
+}
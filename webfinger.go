@@ -0,0 +1,47 @@
+package minifed
+
+import (
+	"net/http"
+	"slices"
+)
+
+const (
+	// WebFingerPath is where WebFinger queries are answered, per RFC 7033.
+	WebFingerPath = "/.well-known/webfinger"
+	// WebFingerIssuerRel is the link relation for an OpenID provider's issuer, per OpenID Connect
+	// Discovery 1.0.
+	WebFingerIssuerRel = "http://openid.net/specs/connect/1.0/issuer"
+)
+
+type jrd struct {
+	Subject string    `json:"subject"`
+	Links   []jrdLink `json:"links"`
+}
+
+type jrdLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+}
+
+// webFingerHandler answers WebFinger queries for any resource, responding with issuer as the
+// OpenID provider issuer that the resource should use.
+func webFingerHandler(issuer string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		resource := query.Get("resource")
+		if resource == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"required parameter 'resource' not given"})
+			return
+		}
+
+		response := jrd{Subject: resource, Links: []jrdLink{}}
+		// The rel parameter filters the links in the response. Without it, all links are returned.
+		rels := query["rel"]
+		if len(rels) == 0 || slices.Contains(rels, WebFingerIssuerRel) {
+			response.Links = append(response.Links, jrdLink{Rel: WebFingerIssuerRel, Href: issuer})
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/jrd+json")
+		writeJSON(w, http.StatusOK, response)
+	}
+}
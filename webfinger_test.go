@@ -0,0 +1,51 @@
+package minifed
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestWebFinger(t *testing.T) {
+	_, handler := buildTestFederation(t, `
+entities:
+  TA:
+    kind: trust-anchor
+    identifier: https://ta.example.com
+  OP:
+    kind: leaf
+    identifier: https://op.example.com
+    openid_provider: true
+    webfinger: true
+edges:
+  - TA -> OP
+`)
+	resp := get(t, handler, "https://op.example.com/.well-known/webfinger?resource=acct:alice@op.example.com")
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/jrd+json" {
+		t.Fatalf("webfinger: status %d, content type %q: %s", resp.Code, resp.Header().Get("Content-Type"), resp.Body)
+	}
+	var response jrd
+	if err := json.Unmarshal(resp.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := jrdLink{Rel: WebFingerIssuerRel, Href: "https://op.example.com"}
+	if response.Subject != "acct:alice@op.example.com" || len(response.Links) != 1 || response.Links[0] != want {
+		t.Errorf("webfinger response is %+v, want the issuer link %+v", response, want)
+	}
+
+	// The rel parameter leaves out the issuer link unless it asks for it.
+	resp = get(t, handler, "https://op.example.com/.well-known/webfinger?resource=acct:alice@op.example.com&rel=http://example.com/other")
+	if err := json.Unmarshal(resp.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Links) != 0 {
+		t.Errorf("webfinger response for another rel has the links %+v", response.Links)
+	}
+	if resp := get(t, handler, "https://op.example.com/.well-known/webfinger"); resp.Code != http.StatusBadRequest {
+		t.Errorf("webfinger without resource: status %d", resp.Code)
+	}
+	// Only the entities that enable it answer WebFinger queries.
+	if resp := get(t, handler, "https://ta.example.com/.well-known/webfinger?resource=acct:alice@ta.example.com"); resp.Code != http.StatusNotFound {
+		t.Errorf("webfinger of TA: status %d", resp.Code)
+	}
+}